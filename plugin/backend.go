@@ -2,7 +2,9 @@ package gaccauth
 
 import (
 	"context"
+	"sync"
 
+	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -18,6 +20,14 @@ type Schema map[string]*framework.FieldSchema
 
 type googleAccountAuthBackend struct {
 	*framework.Backend
+
+	stateLock  sync.Mutex
+	keySet     oidc.KeySet
+	keySetURL  string
+	keySetLock sync.Mutex
+
+	exchanges    map[string]*cachedToken
+	exchangeLock sync.Mutex
 }
 
 func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
@@ -30,27 +40,48 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 }
 
 func newBackend() *googleAccountAuthBackend {
-	b := &googleAccountAuthBackend{}
+	b := &googleAccountAuthBackend{
+		exchanges: map[string]*cachedToken{},
+	}
 
 	b.Backend = &framework.Backend{
-		BackendType: logical.TypeCredential,
-		AuthRenew:   b.authRenew,
-		Help:        backendHelp,
+		BackendType:  logical.TypeCredential,
+		AuthRenew:    b.authRenew,
+		PeriodicFunc: b.periodicFunc,
+		Help:         backendHelp,
 		PathsSpecial: &logical.Paths{
 			Unauthenticated: []string{
 				pathLoginPattern,
 				pathCodeUrlPattern,
+				pathDeviceCodePattern,
+				pathOIDCAuthURLPattern,
+				pathOIDCCallbackPattern,
+				pathWebLoginPattern,
+				pathWebCallbackPattern,
 			},
 		},
 		Paths: framework.PathAppend(
 			pathRoles(b),
+			pathGroups(b),
+			pathUsers(b),
+			pathOIDC(b),
+			pathWeb(b),
 			[]*framework.Path{
 				pathConfig(b),
 				pathLogin(b),
 				pathCodeUrl(b),
+				pathDeviceCode(b),
 			},
 		),
 	}
 
 	return b
 }
+
+func (b *googleAccountAuthBackend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	if err := b.tidyOAuthStates(ctx, req.Storage); err != nil {
+		return err
+	}
+
+	return b.tidyDeviceStates(ctx, req.Storage)
+}
@@ -0,0 +1,349 @@
+package gaccauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/caian-org/vault-plugin-auth-google-acc/emulator"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const testFixture = `
+users:
+  - id: "1001"
+    email: alice@example.com
+    name: Alice Example
+    given_name: Alice
+    hd: example.com
+    aliases: [a.example@example.com]
+    org_unit: /Engineering
+  - id: "1002"
+    email: bob@gmail.com
+    name: Bob
+  - id: "1003"
+    email: carol@example.com
+    name: Carol Example
+    hd: example.com
+groups:
+  - id: group-eng
+    email: eng@example.com
+    aliases: [engineering@example.com]
+    members:
+      - email: alice@example.com
+        role: OWNER
+      - email: platform@example.com
+  - id: group-platform
+    email: platform@example.com
+    members:
+      - email: bob@gmail.com
+  - id: group-ops
+    email: ops@example.com
+    members:
+      - email: alice@example.com
+      - email: carol@example.com
+`
+
+const (
+	testClientID       = "vault-client"
+	testClientSecret   = "vault-secret"
+	testDelegationUser = "admin@example.com"
+	testRedirectURL    = "http://localhost:8250/callback"
+)
+
+// testEnv is a backend configured against a Google emulator serving testFixture.
+type testEnv struct {
+	t        *testing.T
+	backend  *googleAccountAuthBackend
+	storage  logical.Storage
+	emulator *emulator.Server
+	server   *httptest.Server
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	return newTestEnvWithFixture(t, testFixture)
+}
+
+func newTestEnvWithFixture(t *testing.T, fixtureYAML string) *testEnv {
+	t.Helper()
+
+	fixture, err := emulator.ParseFixture([]byte(fixtureYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emu, err := emulator.New(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(emu)
+	t.Cleanup(server.Close)
+
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+
+	b, err := Factory(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &testEnv{
+		t:        t,
+		backend:  b.(*googleAccountAuthBackend),
+		storage:  config.StorageView,
+		emulator: emu,
+		server:   server,
+	}
+
+	e.writeConfig(nil)
+
+	return e
+}
+
+// configData returns the config of a mount pointed at the emulator, with the overrides applied. A nil override
+// removes the property.
+func (e *testEnv) configData(overrides map[string]interface{}) map[string]interface{} {
+	serviceAccountKey, err := e.emulator.ServiceAccountKey(e.server.URL)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+
+	data := map[string]interface{}{
+		pathConfigClientIDProp:          testClientID,
+		pathConfigClientSecretProp:      testClientSecret,
+		pathConfigRedirectURLProp:       testRedirectURL,
+		pathConfigFetchGroupsProp:       true,
+		pathConfigDelegationUserProp:    testDelegationUser,
+		pathConfigServiceAccountKeyProp: string(serviceAccountKey),
+		pathConfigAuthURLProp:           e.server.URL + emulator.AuthPath,
+		pathConfigTokenURLProp:          e.server.URL + emulator.TokenPath,
+		pathConfigDeviceAuthURLProp:     e.server.URL + emulator.DeviceAuthPath,
+		pathConfigUserinfoURLProp:       e.server.URL + emulator.UserinfoPath,
+		pathConfigDirectoryURLProp:      e.server.URL + "/",
+		pathConfigJWKSURLProp:           e.server.URL + emulator.JWKSPath,
+	}
+
+	for prop, value := range overrides {
+		if value == nil {
+			delete(data, prop)
+		} else {
+			data[prop] = value
+		}
+	}
+
+	return data
+}
+
+func (e *testEnv) writeConfig(overrides map[string]interface{}) {
+	e.t.Helper()
+	e.ok(logical.UpdateOperation, pathConfigPattern, e.configData(overrides))
+}
+
+func (e *testEnv) request(op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+	return e.backend.HandleRequest(context.Background(), &logical.Request{
+		Operation:  op,
+		Path:       path,
+		Data:       data,
+		Storage:    e.storage,
+		MountPoint: "auth/google/",
+	})
+}
+
+// ok makes the request and fails the test unless it succeeds.
+func (e *testEnv) ok(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+	e.t.Helper()
+
+	resp, err := e.request(op, path, data)
+	if err != nil {
+		e.t.Fatalf("%s %s: %s", op, path, err)
+	}
+
+	if resp != nil && resp.IsError() {
+		e.t.Fatalf("%s %s: %s", op, path, resp.Error())
+	}
+
+	return resp
+}
+
+// fails makes the request and fails the test unless it is rejected, either with an error or an error response.
+func (e *testEnv) fails(op logical.Operation, path string, data map[string]interface{}) {
+	e.t.Helper()
+
+	resp, err := e.request(op, path, data)
+	if err == nil && (resp == nil || !resp.IsError()) {
+		e.t.Fatalf("%s %s: expected a failure; got %#v", op, path, resp)
+	}
+}
+
+func (e *testEnv) writeRole(name string, data map[string]interface{}) {
+	e.t.Helper()
+	e.ok(logical.UpdateOperation, "role/"+name, data)
+}
+
+// authorize has the user approve the Google authorization of a code_url for the role, and returns the data of the
+// login that follows.
+func (e *testEnv) authorize(email string, role string) map[string]interface{} {
+	e.t.Helper()
+
+	resp := e.ok(logical.ReadOperation, pathCodeUrlPattern, map[string]interface{}{pathCodeUrlRoleNameProp: role})
+	query := e.approve(resp.Data["url"].(string), email)
+
+	return map[string]interface{}{
+		pathLoginGoogleAuthCodeProp: query.Get("code"),
+		pathLoginStateProp:          query.Get("state"),
+		pathLoginRoleNameProp:       role,
+	}
+}
+
+// approve has the user approve the Google authorization URL, and returns the query of the redirect that follows.
+func (e *testEnv) approve(authURL string, email string) url.Values {
+	e.t.Helper()
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	res, err := client.Get(authURL + "&login_hint=" + url.QueryEscape(email))
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		e.t.Fatal(err)
+	}
+
+	return location.Query()
+}
+
+// login completes the code flow of the user for the role.
+func (e *testEnv) login(email string, role string) (*logical.Response, error) {
+	e.t.Helper()
+	return e.request(logical.UpdateOperation, pathLoginPattern, e.authorize(email, role))
+}
+
+// loginOK completes the code flow of the user for the role, and fails the test unless the login succeeds.
+func (e *testEnv) loginOK(email string, role string) *logical.Auth {
+	e.t.Helper()
+	return e.ok(logical.UpdateOperation, pathLoginPattern, e.authorize(email, role)).Auth
+}
+
+// loginFails completes the code flow of the user for the role, and fails the test unless the login is rejected.
+func (e *testEnv) loginFails(email string, role string) {
+	e.t.Helper()
+	e.fails(logical.UpdateOperation, pathLoginPattern, e.authorize(email, role))
+}
+
+// renew renews the token issued for the login, as Vault does: with the token policies, which include the default
+// policy unless the token opted out of it.
+func (e *testEnv) renew(auth *logical.Auth) (*logical.Response, error) {
+	renewed := *auth
+	renewed.TokenPolicies = append([]string{"default"}, auth.Policies...)
+
+	return e.backend.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RenewOperation,
+		Path:      pathLoginPattern,
+		Storage:   e.storage,
+		Auth:      &renewed,
+	})
+}
+
+func (e *testEnv) renewOK(auth *logical.Auth) *logical.Auth {
+	e.t.Helper()
+
+	resp, err := e.renew(auth)
+	if err != nil {
+		e.t.Fatalf("renew: %s", err)
+	}
+
+	if resp.IsError() {
+		e.t.Fatalf("renew: %s", resp.Error())
+	}
+
+	return resp.Auth
+}
+
+func (e *testEnv) renewFails(auth *logical.Auth) {
+	e.t.Helper()
+
+	resp, err := e.renew(auth)
+	if err == nil && !resp.IsError() {
+		e.t.Fatal("renew: expected a failure")
+	}
+}
+
+func (e *testEnv) idToken(email string, audience string) string {
+	e.t.Helper()
+
+	token, err := e.emulator.IDToken(email, audience)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+
+	return token
+}
+
+// startDevice starts a device authorization for the role, and returns its device and user codes.
+func (e *testEnv) startDevice(role string) (string, string) {
+	e.t.Helper()
+
+	resp := e.ok(logical.ReadOperation, pathDeviceCodePattern, map[string]interface{}{pathDeviceCodeRoleNameProp: role})
+	return resp.Data["device_code"].(string), resp.Data["user_code"].(string)
+}
+
+// approveDevice has the user approve the device authorization with the user code.
+func (e *testEnv) approveDevice(userCode string, email string) {
+	e.t.Helper()
+
+	res, err := http.Get(e.server.URL + emulator.DevicePath + "?user_code=" + url.QueryEscape(userCode) + "&login_hint=" + url.QueryEscape(email))
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		e.t.Fatalf("device approval: %s", res.Status)
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+
+func assertPolicies(t *testing.T, auth *logical.Auth, expected ...string) {
+	t.Helper()
+
+	if !strutil.EquivalentSlices(auth.Policies, expected) {
+		t.Fatalf("expected policies %v; got %v", expected, auth.Policies)
+	}
+}
+
+func TestConfig_ReadHidesSecrets(t *testing.T) {
+	e := newTestEnv(t)
+
+	resp := e.ok(logical.ReadOperation, pathConfigPattern, nil)
+	if resp.Data[pathConfigClientIDProp] != testClientID {
+		t.Fatalf("unexpected client id %v", resp.Data[pathConfigClientIDProp])
+	}
+
+	for _, prop := range []string{pathConfigClientSecretProp, pathConfigServiceAccountKeyProp} {
+		if _, ok := resp.Data[prop]; ok {
+			t.Fatalf("%s must not be returned", prop)
+		}
+	}
+}
+
+func TestConfig_Validation(t *testing.T) {
+	e := newTestEnv(t)
+
+	e.fails(logical.UpdateOperation, pathConfigPattern, e.configData(map[string]interface{}{pathConfigClientIDProp: nil}))
+	e.fails(logical.UpdateOperation, pathConfigPattern, e.configData(map[string]interface{}{pathConfigClientSecretProp: nil}))
+	e.fails(logical.UpdateOperation, pathConfigPattern, e.configData(map[string]interface{}{pathConfigRedirectURLProp: "not a url"}))
+	e.fails(logical.UpdateOperation, pathConfigPattern, e.configData(map[string]interface{}{pathConfigUserinfoURLProp: "not a url"}))
+
+	// public clients rely on PKCE instead of a secret
+	e.writeConfig(map[string]interface{}{pathConfigClientSecretProp: nil, pathConfigPublicClientProp: true})
+}
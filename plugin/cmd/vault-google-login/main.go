@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const callbackPath = "/callback"
+
+const callbackPage = `<!DOCTYPE html>
+<html lang="en">
+  <head><meta charset="utf-8"><title>Vault</title></head>
+  <body><p>%s</p></body>
+</html>
+`
+
+type callbackResult struct {
+	code string
+	err  error
+}
+
+func main() {
+	mount := flag.String("mount", "google", "path the Google auth method is mounted at")
+	role := flag.String("role", "", "name of the role to login against")
+	timeout := flag.Duration("timeout", 5*time.Minute, "how long to wait for the Google login to complete")
+	noBrowser := flag.Bool("no-browser", false, "print the Google login URL instead of opening the browser")
+	flag.Parse()
+
+	if *role == "" {
+		log.Fatal("missing role")
+	}
+
+	client, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		log.Fatal("could not create Vault client: ", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal("could not start the redirect listener: ", err)
+	}
+
+	redirectURI := fmt.Sprintf("http://%s%s", listener.Addr().String(), callbackPath)
+
+	secret, err := client.Logical().ReadWithData(fmt.Sprintf("auth/%s/code_url", *mount), map[string][]string{
+		"role":         {*role},
+		"redirect_uri": {redirectURI},
+	})
+	if err != nil {
+		log.Fatal("could not get the Google login URL: ", err)
+	}
+
+	if secret == nil {
+		log.Fatalf("no response from auth/%s/code_url; is the auth method mounted?", *mount)
+	}
+
+	authURL, _ := secret.Data["url"].(string)
+	state, _ := secret.Data["state"].(string)
+	if authURL == "" || state == "" {
+		log.Fatalf("auth/%s/code_url returned no login URL or state", *mount)
+	}
+
+	// the listener only starts once the state is known, so that no redirect is accepted without it
+	results := make(chan callbackResult, 1)
+
+	server := &http.Server{Handler: callbackHandler(state, results)}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			results <- callbackResult{err: err}
+		}
+	}()
+	defer server.Shutdown(context.Background())
+
+	if *noBrowser || openBrowser(authURL) != nil {
+		fmt.Fprintf(os.Stderr, "Open the following URL in your browser to login:\n\n    %s\n\n", authURL)
+	} else {
+		fmt.Fprintln(os.Stderr, "Waiting for the Google login to complete in your browser...")
+	}
+
+	var result callbackResult
+	select {
+	case result = <-results:
+	case <-time.After(*timeout):
+		log.Fatal("timed out waiting for the Google login")
+	}
+
+	if result.err != nil {
+		log.Fatal("Google login failed: ", result.err)
+	}
+
+	secret, err = client.Logical().Write(fmt.Sprintf("auth/%s/login", *mount), map[string]interface{}{
+		"code":  result.code,
+		"state": state,
+		"role":  *role,
+	})
+	if err != nil {
+		log.Fatal("could not login: ", err)
+	}
+
+	if secret == nil || secret.Auth == nil {
+		log.Fatal("no token was returned by the login")
+	}
+
+	if err := storeToken(secret.Auth.ClientToken); err != nil {
+		log.Fatal("could not store the token: ", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Success! You are now authenticated as %s with policies %v.\n", secret.Auth.Metadata["username"], secret.Auth.Policies)
+}
+
+// callbackHandler receives Google's redirect and hands the authorization code over to the login. Redirects that do
+// not carry the state of the login are not Google's, e.g. a page forging a redirect to the loopback listener, and are
+// turned down while waiting for the real one.
+func callbackHandler(state string, results chan<- callbackResult) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(callbackPath, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state)) != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, callbackPage, "Invalid login state. Start the login again from your terminal.")
+			return
+		}
+
+		result := callbackResult{code: query.Get("code")}
+		if googleErr := query.Get("error"); googleErr != "" {
+			result.err = errors.New(googleErr)
+		} else if result.code == "" {
+			result.err = errors.New("no authorization code in the redirect")
+		}
+
+		if result.err != nil {
+			fmt.Fprintf(w, callbackPage, "Login failed. Check your terminal for details.")
+		} else {
+			fmt.Fprintf(w, callbackPage, "Login completed. You can close this window.")
+		}
+
+		select {
+		case results <- result:
+		default:
+		}
+	})
+
+	return mux
+}
+
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+
+	return cmd.Start()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// storeToken saves the token the same way the vault CLI does: through the token helper set in the CLI configuration
+// file, or in ~/.vault-token when there is none.
+func storeToken(token string) error {
+	helper, err := tokenHelperPath()
+	if err != nil {
+		return err
+	}
+
+	if helper != "" {
+		cmd := exec.Command(helper, "store")
+		cmd.Stdin = strings.NewReader(token)
+		cmd.Stderr = os.Stderr
+
+		return cmd.Run()
+	}
+
+	home, err := homedir.Dir()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(home, ".vault-token"), []byte(token), 0o600)
+}
+
+func tokenHelperPath() (string, error) {
+	path := os.Getenv("VAULT_CONFIG_PATH")
+	if path == "" {
+		home, err := homedir.Dir()
+		if err != nil {
+			return "", err
+		}
+
+		path = filepath.Join(home, ".vault")
+	}
+
+	contents, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	var config struct {
+		TokenHelper string `hcl:"token_helper"`
+	}
+
+	if err := hcl.Decode(&config, string(bytes.TrimSpace(contents))); err != nil {
+		return "", err
+	}
+
+	if config.TokenHelper == "" {
+		return "", nil
+	}
+
+	return homedir.Expand(config.TokenHelper)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/caian-org/vault-plugin-auth-google-acc/emulator"
+)
+
+const emulateUsage = `Serves a fake Google for offline development and integration tests. Configure the plugin with:
+
+    vault write auth/google/config \
+        client_id=emulator client_secret=emulator \
+        redirect_url=<REDIRECT-URL> \
+        auth_url=%[1]s%[2]s \
+        token_url=%[1]s%[3]s \
+        device_auth_url=%[1]s%[4]s \
+        userinfo_url=%[1]s%[5]s \
+        jwks_url=%[1]s%[6]s \
+        directory_url=%[1]s/ \
+        fetch_groups=true delegation_user=admin@example.com \
+        service_acc_key=@<(curl -s %[1]s%[7]s)
+
+`
+
+// emulate runs the fake Google server from the emulator package.
+func emulate(args []string) {
+	flags := flag.NewFlagSet("emulate", flag.ExitOnError)
+	addr := flags.String("addr", "127.0.0.1:8085", "address to listen on")
+	fixturePath := flags.String("fixture", "", "path to the YAML fixture of users and groups")
+	if err := flags.Parse(args); err != nil {
+		log.Fatal("could not parse flags: ", err)
+	}
+
+	if *fixturePath == "" {
+		log.Fatal("missing fixture")
+	}
+
+	fixture, err := emulator.LoadFixture(*fixturePath)
+	if err != nil {
+		log.Fatal("could not load fixture: ", err)
+	}
+
+	server, err := emulator.New(fixture)
+	if err != nil {
+		log.Fatal("could not start emulator: ", err)
+	}
+
+	baseURL := fmt.Sprintf("http://%s", *addr)
+	fmt.Printf(emulateUsage, baseURL, emulator.AuthPath, emulator.TokenPath, emulator.DeviceAuthPath,
+		emulator.UserinfoPath, emulator.JWKSPath, emulator.ServiceAccountPath)
+
+	log.Fatal(http.ListenAndServe(*addr, server))
+}
@@ -10,10 +10,15 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "emulate" {
+		emulate(os.Args[2:])
+		return
+	}
+
 	apiClientMeta := &api.PluginAPIClientMeta{}
 	flags := apiClientMeta.FlagSet()
 	if err := flags.Parse(os.Args[1:]); err != nil {
-		log.Fatal("could not parse flags", err)
+		log.Fatal("could not parse flags: ", err)
 	}
 
 	pluginOpts := &plugin.ServeOpts{
@@ -23,6 +28,6 @@ func main() {
 
 	err := plugin.Serve(pluginOpts)
 	if err != nil {
-		log.Fatal("plugin shutting down", err)
+		log.Fatal("plugin shutting down: ", err)
 	}
 }
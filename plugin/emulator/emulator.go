@@ -0,0 +1,647 @@
+// Package emulator implements a fake Google server for offline development and integration tests. It serves the
+// OAuth, userinfo, JWKS and Admin Directory endpoints used by the plugin, backed by a fixture of users and groups.
+// Point the plugin at it through the endpoint overrides of its config path.
+package emulator
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	directory "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/googleapi"
+	goauth "google.golang.org/api/oauth2/v2"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	// Issuer is the "iss" claim of the ID tokens signed by the emulator; the same as Google's.
+	Issuer = "https://accounts.google.com"
+
+	AuthPath           = "/o/oauth2/auth"
+	TokenPath          = "/token"
+	DeviceAuthPath     = "/device/code"
+	DevicePath         = "/device"
+	UserinfoPath       = "/oauth2/v2/userinfo"
+	JWKSPath           = "/oauth2/v3/certs"
+	DirectoryPath      = "/admin/directory/v1/"
+	ServiceAccountPath = "/service_account.json"
+	tokenLifetime      = time.Hour
+	defaultPageSize    = 200
+)
+
+// grant is an authorization, either from the code or the device flow, waiting to be exchanged for a token.
+type grant struct {
+	email         string
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	approved      bool
+	expiresAt     time.Time
+}
+
+// issuedTo tells whether the token request comes from the OAuth client the grant was issued to.
+func (g *grant) issuedTo(r *http.Request) bool {
+	return g.clientID == "" || g.clientID == r.PostForm.Get("client_id")
+}
+
+type Server struct {
+	fixture *Fixture
+	key     *rsa.PrivateKey
+	keyID   string
+	signer  jose.Signer
+	mux     *http.ServeMux
+
+	lock          sync.Mutex
+	codes         map[string]*grant
+	devices       map[string]*grant
+	userCodes     map[string]string
+	accessTokens  map[string]string
+	refreshTokens map[string]*grant
+}
+
+// New creates an emulator serving the given fixture. The key ID tokens are signed with is generated on creation.
+func New(fixture *Fixture) (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID := randomString(8)
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: keyID}},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		fixture:       fixture,
+		key:           key,
+		keyID:         keyID,
+		signer:        signer,
+		mux:           http.NewServeMux(),
+		codes:         map[string]*grant{},
+		devices:       map[string]*grant{},
+		userCodes:     map[string]string{},
+		accessTokens:  map[string]string{},
+		refreshTokens: map[string]*grant{},
+	}
+
+	s.mux.HandleFunc(AuthPath, s.handleAuth)
+	s.mux.HandleFunc(TokenPath, s.handleToken)
+	s.mux.HandleFunc(DeviceAuthPath, s.handleDeviceAuth)
+	s.mux.HandleFunc(DevicePath, s.handleDevice)
+	s.mux.HandleFunc(UserinfoPath, s.handleUserinfo)
+	s.mux.HandleFunc(JWKSPath, s.handleJWKS)
+	s.mux.HandleFunc(DirectoryPath, s.handleDirectory)
+	s.mux.HandleFunc(ServiceAccountPath, s.handleServiceAccount)
+
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// IDToken signs an ID token for the user, issued to the given audience (an OAuth client ID).
+func (s *Server) IDToken(email string, audience string) (string, error) {
+	user := s.fixture.user(email)
+	if user == nil {
+		return "", fmt.Errorf("unknown user '%s'", email)
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":            Issuer,
+		"aud":            audience,
+		"azp":            audience,
+		"sub":            user.ID,
+		"email":          user.Email,
+		"email_verified": *user.EmailVerified,
+		"iat":            now.Unix(),
+		"exp":            now.Add(tokenLifetime).Unix(),
+	}
+
+	optional := map[string]string{
+		"name":        user.Name,
+		"given_name":  user.GivenName,
+		"family_name": user.FamilyName,
+		"hd":          user.HostedDomain,
+	}
+
+	for name, value := range optional {
+		if value != "" {
+			claims[name] = value
+		}
+	}
+
+	return jwt.Signed(s.signer).Claims(claims).CompactSerialize()
+}
+
+// ServiceAccountKey returns a service account key, in the format of the JSON files downloaded from Google Cloud,
+// whose token URI is the emulator's. Any service account assertion is accepted by the emulator.
+func (s *Server) ServiceAccountKey(baseURL string) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(s.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(map[string]string{
+		"type":           "service_account",
+		"project_id":     "emulator",
+		"private_key_id": s.keyID,
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email":   "vault@emulator.iam.gserviceaccount.com",
+		"client_id":      "emulator",
+		"token_uri":      strings.TrimSuffix(baseURL, "/") + TokenPath,
+	}, "", "  ")
+}
+
+///////////////////////////////////////////////////////////////////////////////
+
+var authPage = template.Must(template.New("auth").Parse(`<!DOCTYPE html>
+<html lang="en">
+  <head><meta charset="utf-8"><title>Google emulator</title></head>
+  <body>
+    <h1>Choose an account</h1>
+    <ul>
+      {{ range .Users }}<li><a href="?{{ $.Query }}&login_hint={{ .Email }}">{{ .Email }}</a></li>{{ end }}
+    </ul>
+  </body>
+</html>
+`))
+
+// handleAuth signs the user given by the login_hint parameter in, or lists the fixture users to pick from.
+func (s *Server) handleAuth(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirectURI.String() == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	email := query.Get("login_hint")
+	if email == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		authPage.Execute(w, map[string]interface{}{"Users": s.fixture.Users, "Query": template.URL(r.URL.RawQuery)})
+		return
+	}
+
+	user := s.fixture.user(email)
+	if user == nil {
+		http.Error(w, "unknown user", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString(16)
+
+	s.lock.Lock()
+	s.codes[code] = &grant{
+		email:         user.Email,
+		clientID:      query.Get("client_id"),
+		redirectURI:   redirectURI.String(),
+		codeChallenge: query.Get("code_challenge"),
+		approved:      true,
+		expiresAt:     time.Now().Add(10 * time.Minute),
+	}
+	s.lock.Unlock()
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code := r.PostForm.Get("code")
+		g, ok := s.codes[code]
+		delete(s.codes, code)
+
+		if !ok || time.Now().After(g.expiresAt) || g.redirectURI != r.PostForm.Get("redirect_uri") {
+			tokenError(w, "invalid_grant")
+			return
+		}
+
+		if g.codeChallenge != "" && g.codeChallenge != s256(r.PostForm.Get("code_verifier")) {
+			tokenError(w, "invalid_grant")
+			return
+		}
+
+		if !g.issuedTo(r) {
+			tokenError(w, "invalid_client")
+			return
+		}
+
+		s.issueToken(w, g)
+
+	case "refresh_token":
+		g, ok := s.refreshTokens[r.PostForm.Get("refresh_token")]
+		if !ok {
+			tokenError(w, "invalid_grant")
+			return
+		}
+
+		if !g.issuedTo(r) {
+			tokenError(w, "invalid_client")
+			return
+		}
+
+		s.issueToken(w, g)
+
+	case "urn:ietf:params:oauth:grant-type:device_code":
+		deviceCode := r.PostForm.Get("device_code")
+		g, ok := s.devices[deviceCode]
+
+		switch {
+		case !ok:
+			tokenError(w, "invalid_grant")
+		case !g.issuedTo(r):
+			tokenError(w, "invalid_client")
+		case time.Now().After(g.expiresAt):
+			delete(s.devices, deviceCode)
+			tokenError(w, "expired_token")
+		case !g.approved:
+			tokenError(w, "authorization_pending")
+		default:
+			delete(s.devices, deviceCode)
+			s.issueToken(w, g)
+		}
+
+	case "urn:ietf:params:oauth:grant-type:jwt-bearer":
+		// service account assertions are not verified; the token grants access to the directory endpoints only
+		if !s.fixture.delegated(r.PostForm.Get("assertion")) {
+			tokenError(w, "unauthorized_client")
+			return
+		}
+
+		accessToken := randomString(24)
+		s.accessTokens[accessToken] = ""
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"access_token": accessToken,
+			"token_type":   "Bearer",
+			"expires_in":   int(tokenLifetime.Seconds()),
+		})
+
+	default:
+		tokenError(w, "unsupported_grant_type")
+	}
+}
+
+// issueToken answers the token endpoint with a new access token for the grant. The lock must be held.
+func (s *Server) issueToken(w http.ResponseWriter, g *grant) {
+	accessToken := randomString(24)
+	refreshToken := randomString(24)
+
+	s.accessTokens[accessToken] = g.email
+	s.refreshTokens[refreshToken] = g
+
+	idToken, err := s.IDToken(g.email, g.clientID)
+	if err != nil {
+		tokenError(w, "server_error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"id_token":      idToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(tokenLifetime.Seconds()),
+	})
+}
+
+func (s *Server) handleDeviceAuth(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	deviceCode := randomString(24)
+	userCode := strings.ToUpper(randomString(6))
+
+	s.lock.Lock()
+	s.devices[deviceCode] = &grant{
+		clientID:  r.PostForm.Get("client_id"),
+		expiresAt: time.Now().Add(30 * time.Minute),
+	}
+	s.userCodes[userCode] = deviceCode
+	s.lock.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"device_code":      deviceCode,
+		"user_code":        userCode,
+		"verification_url": fmt.Sprintf("http://%s%s", r.Host, DevicePath),
+		"expires_in":       1800,
+		"interval":         1,
+	})
+}
+
+// handleDevice approves the device authorization with the given user_code for the user given by login_hint.
+func (s *Server) handleDevice(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	user := s.fixture.user(query.Get("login_hint"))
+	if user == nil {
+		http.Error(w, "unknown user; set the user_code and login_hint parameters", http.StatusBadRequest)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	userCode := strings.ToUpper(query.Get("user_code"))
+	g, ok := s.devices[s.userCodes[userCode]]
+	if !ok {
+		http.Error(w, "unknown user_code", http.StatusBadRequest)
+		return
+	}
+
+	g.email = user.Email
+	g.approved = true
+	delete(s.userCodes, userCode)
+
+	fmt.Fprintln(w, "Device approved. You can close this window.")
+}
+
+func (s *Server) handleUserinfo(w http.ResponseWriter, r *http.Request) {
+	email, ok := s.bearer(r)
+	if !ok || email == "" {
+		writeJSON(w, http.StatusUnauthorized, apiError(http.StatusUnauthorized, "invalid credentials"))
+		return
+	}
+
+	user := s.fixture.user(email)
+
+	writeJSON(w, http.StatusOK, &goauth.Userinfo{
+		Id:            user.ID,
+		Email:         user.Email,
+		VerifiedEmail: user.EmailVerified,
+		Name:          user.Name,
+		GivenName:     user.GivenName,
+		FamilyName:    user.FamilyName,
+		Hd:            user.HostedDomain,
+	})
+}
+
+func (s *Server) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{{Key: &s.key.PublicKey, KeyID: s.keyID, Algorithm: "RS256", Use: "sig"}},
+	})
+}
+
+func (s *Server) handleDirectory(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.bearer(r); !ok {
+		writeJSON(w, http.StatusUnauthorized, apiError(http.StatusUnauthorized, "invalid credentials"))
+		return
+	}
+
+	segments := strings.Split(strings.TrimPrefix(r.URL.Path, DirectoryPath), "/")
+
+	switch {
+	case len(segments) == 1 && segments[0] == "groups":
+		s.handleGroupsList(w, r)
+	case len(segments) == 2 && segments[0] == "groups":
+		s.handleGroupGet(w, segments[1])
+	case len(segments) == 4 && segments[0] == "groups" && segments[2] == "hasMember":
+		s.handleHasMember(w, segments[1], segments[3])
+	case len(segments) == 4 && segments[0] == "groups" && segments[2] == "members":
+		s.handleMemberGet(w, segments[1], segments[3])
+	case len(segments) == 2 && segments[0] == "users":
+		s.handleUserGet(w, r, segments[1])
+	default:
+		writeJSON(w, http.StatusNotFound, apiError(http.StatusNotFound, "not found"))
+	}
+}
+
+func (s *Server) handleGroupGet(w http.ResponseWriter, groupKey string) {
+	group := s.fixture.group(groupKey)
+	if group == nil {
+		writeJSON(w, http.StatusNotFound, apiError(http.StatusNotFound, "Resource Not Found: groupKey"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, directoryGroup(group))
+}
+
+// handleHasMember tells whether the user is a member of the group, directly or through nested groups.
+func (s *Server) handleHasMember(w http.ResponseWriter, groupKey string, memberKey string) {
+	group := s.fixture.group(groupKey)
+	if group == nil {
+		writeJSON(w, http.StatusNotFound, apiError(http.StatusNotFound, "Resource Not Found: groupKey"))
+		return
+	}
+
+	user := s.fixture.user(memberKey)
+	if user == nil {
+		writeJSON(w, http.StatusBadRequest, apiError(http.StatusBadRequest, "Invalid Input: memberKey"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &directory.MembersHasMember{IsMember: s.fixture.hasMember(group, user.Email)})
+}
+
+// handleGroupsList serves the groups a user, or a group, is a direct member of, paginated like the Directory API.
+func (s *Server) handleGroupsList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var email string
+	if user := s.fixture.user(query.Get("userKey")); user != nil {
+		email = user.Email
+	} else if group := s.fixture.group(query.Get("userKey")); group != nil {
+		email = group.Email
+	} else {
+		writeJSON(w, http.StatusNotFound, apiError(http.StatusNotFound, "Resource Not Found: userKey"))
+		return
+	}
+
+	groups := s.fixture.directGroups(email)
+
+	pageSize, err := strconv.Atoi(query.Get("maxResults"))
+	if err != nil || pageSize < 1 {
+		pageSize = defaultPageSize
+	}
+
+	start, _ := strconv.Atoi(query.Get("pageToken"))
+	if start > len(groups) {
+		start = len(groups)
+	}
+
+	end := start + pageSize
+	if end > len(groups) {
+		end = len(groups)
+	}
+
+	response := &directory.Groups{Kind: "admin#directory#groups", Groups: []*directory.Group{}}
+	for _, group := range groups[start:end] {
+		response.Groups = append(response.Groups, directoryGroup(group))
+	}
+
+	if end < len(groups) {
+		response.NextPageToken = strconv.Itoa(end)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) handleServiceAccount(w http.ResponseWriter, r *http.Request) {
+	key, err := s.ServiceAccountKey(fmt.Sprintf("http://%s", r.Host))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(key)
+}
+
+///////////////////////////////////////////////////////////////////////////////
+
+// bearer returns the email address the request's access token was issued to; empty for service accounts.
+func (s *Server) bearer(r *http.Request) (string, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	email, ok := s.accessTokens[token]
+	return email, ok
+}
+
+// handleMemberGet serves a direct membership of a group, with the role the member holds in it.
+func (s *Server) handleMemberGet(w http.ResponseWriter, groupKey string, memberKey string) {
+	group := s.fixture.group(groupKey)
+	if group == nil {
+		writeJSON(w, http.StatusNotFound, apiError(http.StatusNotFound, "Resource Not Found: groupKey"))
+		return
+	}
+
+	for _, member := range group.Members {
+		if strings.EqualFold(member.Email, memberKey) {
+			writeJSON(w, http.StatusOK, &directory.Member{
+				Kind:  "admin#directory#member",
+				Email: member.Email,
+				Role:  member.Role,
+			})
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusNotFound, apiError(http.StatusNotFound, "Resource Not Found: memberKey"))
+}
+
+func (s *Server) handleUserGet(w http.ResponseWriter, r *http.Request, userKey string) {
+	user := s.fixture.user(userKey)
+	if user == nil {
+		writeJSON(w, http.StatusNotFound, apiError(http.StatusNotFound, "Resource Not Found: userKey"))
+		return
+	}
+
+	// like Google, the Directory only serves the accounts of the customer's domains
+	if user.HostedDomain == "" {
+		writeJSON(w, http.StatusForbidden, apiError(http.StatusForbidden, "Not Authorized to access this resource/api"))
+		return
+	}
+
+	// custom schemas are only returned when asked for, restricted to the ones in the field mask when it is set
+	customSchemas := map[string]googleapi.RawMessage{}
+	if projection := r.URL.Query().Get("projection"); projection == "custom" || projection == "full" {
+		mask := strings.Split(r.URL.Query().Get("customFieldMask"), ",")
+		for schema, fields := range user.CustomSchemas {
+			if mask[0] != "" && !containsFold(mask, schema) {
+				continue
+			}
+
+			encoded, err := json.Marshal(fields)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, apiError(http.StatusInternalServerError, err.Error()))
+				return
+			}
+
+			customSchemas[schema] = encoded
+		}
+	}
+
+	writeJSON(w, http.StatusOK, &directory.User{
+		Kind:          "admin#directory#user",
+		CustomSchemas: customSchemas,
+		Id:            user.ID,
+		PrimaryEmail:  user.Email,
+		Aliases:       user.Aliases,
+		OrgUnitPath:   user.OrgUnit,
+		Name: &directory.UserName{
+			FullName:   user.Name,
+			GivenName:  user.GivenName,
+			FamilyName: user.FamilyName,
+		},
+	})
+}
+
+func directoryGroup(group *Group) *directory.Group {
+	return &directory.Group{
+		Kind:        "admin#directory#group",
+		Id:          group.ID,
+		Email:       group.Email,
+		Name:        group.Name,
+		Description: group.Description,
+		Aliases:     group.Aliases,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func apiError(code int, message string) map[string]interface{} {
+	return map[string]interface{}{
+		"error": map[string]interface{}{"code": code, "message": message},
+	}
+}
+
+func s256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString(size int) string {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
@@ -0,0 +1,219 @@
+package emulator
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/square/go-jose.v2/jwt"
+	"gopkg.in/yaml.v3"
+)
+
+// Fixture holds the users and groups served by the emulator.
+//
+//	users:
+//	  - id: "1001"
+//	    email: alice@example.com
+//	    name: Alice Example
+//	    hd: example.com
+//	    custom_schemas:
+//	      VaultAccess:
+//	        policies: dev,ops
+//	groups:
+//	  - id: "g-eng"
+//	    email: eng@example.com
+//	    aliases: [engineering@example.com]
+//	    description: "Engineering\n\nvault-policies: eng"
+//	    members:
+//	      - email: alice@example.com
+//	        role: OWNER
+//	delegated_scopes:
+//	  - https://www.googleapis.com/auth/admin.directory.group.readonly
+//
+// Service account tokens are issued for any scope, unless delegated_scopes restricts them as domain-wide delegation
+// does.
+type Fixture struct {
+	Users           []*User  `yaml:"users"`
+	Groups          []*Group `yaml:"groups"`
+	DelegatedScopes []string `yaml:"delegated_scopes"`
+}
+
+type User struct {
+	ID            string                            `yaml:"id"`
+	Email         string                            `yaml:"email"`
+	EmailVerified *bool                             `yaml:"email_verified"`
+	Name          string                            `yaml:"name"`
+	GivenName     string                            `yaml:"given_name"`
+	FamilyName    string                            `yaml:"family_name"`
+	HostedDomain  string                            `yaml:"hd"`
+	Aliases       []string                          `yaml:"aliases"`
+	OrgUnit       string                            `yaml:"org_unit"`
+	CustomSchemas map[string]map[string]interface{} `yaml:"custom_schemas"`
+}
+
+type Group struct {
+	ID          string    `yaml:"id"`
+	Email       string    `yaml:"email"`
+	Name        string    `yaml:"name"`
+	Description string    `yaml:"description"`
+	Aliases     []string  `yaml:"aliases"`
+	Members     []*Member `yaml:"members"`
+}
+
+// Member is either a user or a group (for nested groups) that belongs to a group.
+type Member struct {
+	Email string `yaml:"email"`
+	Role  string `yaml:"role"`
+}
+
+// LoadFixture reads a YAML fixture from disk.
+func LoadFixture(path string) (*Fixture, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseFixture(contents)
+}
+
+// ParseFixture decodes a YAML fixture, filling in the defaults of omitted fields.
+func ParseFixture(contents []byte) (*Fixture, error) {
+	fixture := &Fixture{}
+	if err := yaml.Unmarshal(contents, fixture); err != nil {
+		return nil, err
+	}
+
+	for i, user := range fixture.Users {
+		if user.Email == "" {
+			return nil, fmt.Errorf("user #%d has no email", i+1)
+		}
+
+		if user.ID == "" {
+			user.ID = fmt.Sprintf("%d", 100000+i)
+		}
+
+		if user.EmailVerified == nil {
+			verified := true
+			user.EmailVerified = &verified
+		}
+	}
+
+	for i, group := range fixture.Groups {
+		if group.Email == "" {
+			return nil, fmt.Errorf("group #%d has no email", i+1)
+		}
+
+		if group.ID == "" {
+			group.ID = fmt.Sprintf("group-%d", i+1)
+		}
+
+		for _, member := range group.Members {
+			member.Role = strings.ToUpper(member.Role)
+			if member.Role == "" {
+				member.Role = "MEMBER"
+			}
+		}
+	}
+
+	return fixture, nil
+}
+
+// user finds a user by email address (primary or alias) or ID.
+func (f *Fixture) user(key string) *User {
+	for _, user := range f.Users {
+		if user.ID == key || strings.EqualFold(user.Email, key) || containsFold(user.Aliases, key) {
+			return user
+		}
+	}
+
+	return nil
+}
+
+// group finds a group by email address (primary or alias) or ID.
+func (f *Fixture) group(key string) *Group {
+	for _, group := range f.Groups {
+		if group.ID == key || strings.EqualFold(group.Email, key) || containsFold(group.Aliases, key) {
+			return group
+		}
+	}
+
+	return nil
+}
+
+// directGroups returns the groups the user or group with the given email address is a direct member of.
+func (f *Fixture) directGroups(email string) []*Group {
+	groups := []*Group{}
+
+	for _, group := range f.Groups {
+		for _, member := range group.Members {
+			if strings.EqualFold(member.Email, email) {
+				groups = append(groups, group)
+				break
+			}
+		}
+	}
+
+	return groups
+}
+
+// hasMember tells whether the email address is a member of the group, directly or through nested groups.
+func (f *Fixture) hasMember(group *Group, email string) bool {
+	return f.hasNestedMember(group, email, map[string]bool{})
+}
+
+func (f *Fixture) hasNestedMember(group *Group, email string, visited map[string]bool) bool {
+	if visited[group.ID] {
+		return false
+	}
+
+	visited[group.ID] = true
+
+	for _, member := range group.Members {
+		if strings.EqualFold(member.Email, email) {
+			return true
+		}
+
+		if nested := f.group(member.Email); nested != nil && f.hasNestedMember(nested, email, visited) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// delegated tells whether the scopes a service account assertion asks for are delegated to it.
+func (f *Fixture) delegated(assertion string) bool {
+	if len(f.DelegatedScopes) == 0 {
+		return true
+	}
+
+	token, err := jwt.ParseSigned(assertion)
+	if err != nil {
+		return false
+	}
+
+	var claims struct {
+		Scope string `json:"scope"`
+	}
+	if err := token.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return false
+	}
+
+	for _, scope := range strings.Fields(claims.Scope) {
+		if !containsFold(f.DelegatedScopes, scope) {
+			return false
+		}
+	}
+
+	return true
+}
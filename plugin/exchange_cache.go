@@ -0,0 +1,59 @@
+package gaccauth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// exchangeCacheTimeout bounds how long a token obtained during an alias lookahead is kept for the login that follows.
+const exchangeCacheTimeout = time.Minute
+
+type cachedToken struct {
+	token     *oauth2.Token
+	expiresAt time.Time
+}
+
+func exchangeCacheKey(kind string, code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return kind + "/" + hex.EncodeToString(sum[:])
+}
+
+// cacheExchange keeps the token obtained for an authorization or device code. Authorization codes can only be
+// exchanged once, so the login that follows an alias lookahead must reuse the token instead of exchanging again.
+func (b *googleAccountAuthBackend) cacheExchange(key string, token *oauth2.Token) {
+	b.exchangeLock.Lock()
+	defer b.exchangeLock.Unlock()
+
+	now := time.Now()
+	for k, cached := range b.exchanges {
+		if now.After(cached.expiresAt) {
+			delete(b.exchanges, k)
+		}
+	}
+
+	b.exchanges[key] = &cachedToken{token: token, expiresAt: now.Add(exchangeCacheTimeout)}
+}
+
+// cachedExchange returns the cached token for the key, or nil. The entry is removed when remove is set.
+func (b *googleAccountAuthBackend) cachedExchange(key string, remove bool) *oauth2.Token {
+	b.exchangeLock.Lock()
+	defer b.exchangeLock.Unlock()
+
+	cached, ok := b.exchanges[key]
+	if !ok {
+		return nil
+	}
+
+	if remove || time.Now().After(cached.expiresAt) {
+		delete(b.exchanges, key)
+	}
+
+	if time.Now().After(cached.expiresAt) {
+		return nil
+	}
+
+	return cached.token
+}
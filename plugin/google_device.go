@@ -0,0 +1,250 @@
+package gaccauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
+)
+
+const (
+	deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+	deviceStatePrefix   = "device/"
+)
+
+// errors returned by the token endpoint while the user has not yet finished the device authorization (RFC 8628,
+// section 3.5). The login should be retried later.
+var devicePendingErrors = []string{"authorization_pending", "slow_down"}
+
+// errors returned by the token endpoint once the device authorization can no longer succeed: the user denied it, or
+// it expired or was already used.
+var deviceTerminalErrors = []string{"access_denied", "expired_token", "invalid_grant"}
+
+// deviceAuthorization is the response of Google's device authorization endpoint.
+type deviceAuthorization struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURL string `json:"verification_url"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+// deviceState is the server-side record of a pending device authorization. It is keyed by the hash of the device
+// code, binds the authorization to the role it was requested for and is deleted once the login is completed.
+type deviceState struct {
+	RoleName  string    `json:"role"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type deviceTokenError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *deviceTokenError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
+
+func (e *deviceTokenError) pending() bool {
+	return sliceContains([]string{e.Code}, devicePendingErrors)
+}
+
+func (e *deviceTokenError) terminal() bool {
+	return sliceContains([]string{e.Code}, deviceTerminalErrors)
+}
+
+///////////////////////////////////////////////////////////////////////////////
+
+// deviceClient returns the configuration of the device flow. Google only grants device authorizations to OAuth
+// clients of the "TVs and Limited Input devices" type, which cannot be used by the redirect-based flows, so the
+// device flow runs with its own client when one is configured.
+func (c *googleOAuth) deviceClient() *googleOAuth {
+	if c.DeviceClientID == "" {
+		return c
+	}
+
+	device := *c
+	device.ClientID = c.DeviceClientID
+	device.ClientSecret = c.DeviceClientSecret
+	device.PublicClient = false
+
+	return &device
+}
+
+///////////////////////////////////////////////////////////////////////////////
+
+func (c *googleOAuth) postForm(ctx context.Context, endpoint string, values url.Values, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(values.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := cleanhttp.DefaultClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		tokenErr := &deviceTokenError{}
+		if err := json.Unmarshal(body, tokenErr); err != nil || tokenErr.Code == "" {
+			return fmt.Errorf("unexpected response from Google (%d): %s", res.StatusCode, body)
+		}
+
+		return tokenErr
+	}
+
+	return json.Unmarshal(body, result)
+}
+
+// startDeviceAuthorization requests a device and user code from Google.
+func (c *googleOAuth) startDeviceAuthorization(ctx context.Context) (*deviceAuthorization, error) {
+	values := url.Values{
+		"client_id": {c.ClientID},
+		"scope":     {strings.Join(c.build().Scopes, " ")},
+	}
+
+	result := &deviceAuthorization{}
+	if err := c.postForm(ctx, stringOrDefault(c.DeviceAuthURL, googleDeviceAuthURL), values, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// pollDeviceToken makes a single attempt to exchange the device code for a token. While the user has not approved
+// the authorization, a *deviceTokenError that reports itself as pending is returned.
+func (c *googleOAuth) pollDeviceToken(ctx context.Context, deviceCode string) (*oauth2.Token, error) {
+	values := url.Values{
+		"client_id":     {c.ClientID},
+		"client_secret": {c.ClientSecret},
+		"device_code":   {deviceCode},
+		"grant_type":    {deviceCodeGrantType},
+	}
+
+	var result struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int    `json:"expires_in"`
+		IDToken      string `json:"id_token"`
+	}
+
+	if err := c.postForm(ctx, c.build().Endpoint.TokenURL, values, &result); err != nil {
+		return nil, err
+	}
+
+	token := &oauth2.Token{
+		AccessToken:  result.AccessToken,
+		TokenType:    result.TokenType,
+		RefreshToken: result.RefreshToken,
+	}
+
+	if result.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	}
+
+	return token.WithExtra(map[string]interface{}{"id_token": result.IDToken}), nil
+}
+
+///////////////////////////////////////////////////////////////////////////////
+
+func deviceStateKey(deviceCode string) string {
+	sum := sha256.Sum256([]byte(deviceCode))
+	return deviceStatePrefix + hex.EncodeToString(sum[:])
+}
+
+func (b *googleAccountAuthBackend) createDeviceState(ctx context.Context, storage logical.Storage, deviceCode string, roleName string, expiresIn int) error {
+	state := &deviceState{
+		RoleName:  roleName,
+		ExpiresAt: time.Now().Add(time.Duration(expiresIn) * time.Second),
+	}
+
+	entry, err := logical.StorageEntryJSON(deviceStateKey(deviceCode), state)
+	if err != nil {
+		return err
+	}
+
+	return storage.Put(ctx, entry)
+}
+
+// getDeviceState returns the pending device authorization for the device code, or nil if it is unknown. Expired
+// authorizations are deleted and reported as an error.
+func (b *googleAccountAuthBackend) getDeviceState(ctx context.Context, storage logical.Storage, deviceCode string) (*deviceState, error) {
+	entry, err := storage.Get(ctx, deviceStateKey(deviceCode))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var state deviceState
+	if err := entry.DecodeJSON(&state); err != nil {
+		return nil, fmt.Errorf("error reading device authorization: %s", err)
+	}
+
+	if time.Now().After(state.ExpiresAt) {
+		if err := b.deleteDeviceState(ctx, storage, deviceCode); err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("device authorization has expired")
+	}
+
+	return &state, nil
+}
+
+func (b *googleAccountAuthBackend) deleteDeviceState(ctx context.Context, storage logical.Storage, deviceCode string) error {
+	return storage.Delete(ctx, deviceStateKey(deviceCode))
+}
+
+// tidyDeviceStates removes device authorizations that were started but never completed.
+func (b *googleAccountAuthBackend) tidyDeviceStates(ctx context.Context, storage logical.Storage) error {
+	keys, err := storage.List(ctx, deviceStatePrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		entry, err := storage.Get(ctx, deviceStatePrefix+key)
+		if err != nil {
+			return err
+		}
+
+		if entry == nil {
+			continue
+		}
+
+		var state deviceState
+		if err := entry.DecodeJSON(&state); err != nil || time.Now().After(state.ExpiresAt) {
+			if err := storage.Delete(ctx, deviceStatePrefix+key); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,347 @@
+package gaccauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"golang.org/x/oauth2/google"
+	directory "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/option"
+)
+
+const directoryGroupScope = "https://www.googleapis.com/auth/admin.directory.group.readonly"
+
+// groupMemberRoles are the roles a member can hold in a Google group.
+var groupMemberRoles = []string{"OWNER", "MANAGER", "MEMBER"}
+
+// googleGroup is a Google group the user is a member of.
+type googleGroup struct {
+	ID          string
+	Email       string
+	Aliases     []string
+	Description string
+}
+
+// matches tells whether the group is the one referred to by its email address, one of its aliases or its ID.
+func (g *googleGroup) matches(key string) bool {
+	if key == g.ID || strings.EqualFold(key, g.Email) {
+		return true
+	}
+
+	for _, alias := range g.Aliases {
+		if strings.EqualFold(key, alias) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// groupsMatch tells whether any of the groups is referred to by any of the keys.
+func groupsMatch(groups []*googleGroup, keys []string) bool {
+	for _, group := range groups {
+		for _, key := range keys {
+			if group.matches(key) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// groupAddresses returns the email addresses, primary and aliases, of the groups.
+func groupAddresses(groups []*googleGroup) []string {
+	addresses := []string{}
+	for _, group := range groups {
+		addresses = append(append(addresses, group.Email), group.Aliases...)
+	}
+
+	return addresses
+}
+
+// groupsMatchAll tells whether every one of the keys refers to one of the groups.
+func groupsMatchAll(groups []*googleGroup, keys []string) bool {
+	for _, key := range keys {
+		if !groupsMatch(groups, []string{key}) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func directoryGroup(g *directory.Group) *googleGroup {
+	return &googleGroup{
+		ID:          g.Id,
+		Email:       g.Email,
+		Aliases:     append(append([]string{}, g.Aliases...), g.NonEditableAliases...),
+		Description: g.Description,
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+
+// directoryService returns an Admin Directory client authenticated as the service account, impersonating the
+// delegation user.
+func (c *googleOAuth) directoryService(ctx context.Context, scopes ...string) (*directory.Service, error) {
+	saCredential, err := google.JWTConfigFromJSON([]byte(c.ServiceAccount), scopes...)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.TokenURL != "" {
+		saCredential.TokenURL = c.TokenURL
+	}
+
+	saCredential.Subject = c.DelegationUser
+
+	return directory.NewService(
+		ctx,
+		option.WithHTTPClient(saCredential.Client(ctx)),
+		option.WithEndpoint(stringOrDefault(c.DirectoryURL, googleDirectoryURL)),
+	)
+}
+
+// fetchGroups returns the groups of the user. Indirect memberships, through nested groups, are included when either
+// the configuration or the role asks for them.
+func (b *googleAccountAuthBackend) fetchGroups(googleOAuth *googleOAuth, role *googleAuthRole, email string) ([]*googleGroup, error) {
+	if !googleOAuth.FetchGroups {
+		return []*googleGroup{}, nil
+	}
+
+	ctx := context.Background()
+	service, err := googleOAuth.directoryService(ctx, directoryGroupScope)
+	if err != nil {
+		return nil, err
+	}
+
+	if googleOAuth.groupLookup() == groupLookupCheck {
+		// a role that only needs one of its groups is decided by the first one the user is a member of, unless policies
+		// are derived from all of them
+		firstOnly := role.groupMatchMode() == groupMatchAny && len(role.RequiredGroups) == 0 && len(role.DeniedGroups) == 0 &&
+			len(role.GroupPolicyTemplates) == 0 && googleOAuth.PolicyGroupKey == ""
+		groupKeys := append(append(append([]string{}, role.BoundGroups...), role.RequiredGroups...), role.DeniedGroups...)
+		return checkGroups(ctx, service, email, groupKeys, firstOnly)
+	}
+
+	if googleOAuth.Transitive || role.TransitiveGroups {
+		return resolveTransitiveGroups(ctx, service, email, googleOAuth.maxGroupDepth())
+	}
+
+	groups, err := resolveGroups(ctx, service, email)
+	if err != nil {
+		return nil, err
+	}
+
+	// the user may only be in a denied group through a nested group, which the direct memberships do not show; denied
+	// groups are checked the way check lookups do, following nested groups
+	if len(role.DeniedGroups) > 0 {
+		deniedGroups, err := checkGroups(ctx, service, email, role.DeniedGroups, false)
+		if err != nil {
+			return nil, err
+		}
+
+		groups = append(groups, deniedGroups...)
+	}
+
+	return groups, nil
+}
+
+// checkGroups asks the Directory, concurrently, whether the user is a member (directly or through nested groups) of
+// each of the given groups, instead of listing all the groups of the user. The groups are returned in the order of
+// the keys, so the result does not depend on which check answers first. With firstOnly, the checks stop at the first
+// key, in that order, the user is a member of, and only its group is returned. Groups unknown to the Directory, such
+// as deleted ones, have no members.
+func checkGroups(ctx context.Context, service *directory.Service, email string, groupKeys []string, firstOnly bool) ([]*googleGroup, error) {
+	groupKeys = strutil.RemoveDuplicatesStable(groupKeys, false)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		isMember bool
+		err      error
+	}
+
+	results := make([]result, len(groupKeys))
+	answered := make([]bool, len(groupKeys))
+	answers := make(chan int, len(groupKeys))
+
+	for i, groupKey := range groupKeys {
+		go func(i int, groupKey string) {
+			response, err := service.Members.HasMember(groupKey, email).Context(ctx).Do()
+			if isGoogleAPIError(err, http.StatusNotFound) {
+				err = nil
+			}
+
+			results[i] = result{isMember: err == nil && response != nil && response.IsMember, err: err}
+			answers <- i
+		}(i, groupKey)
+	}
+
+	// checks are decided in key order: a failed check might have been a match, so a later match cannot decide alone
+	next := 0
+	for pending := len(groupKeys); pending > 0; pending-- {
+		answered[<-answers] = true
+
+		if !firstOnly {
+			continue
+		}
+
+		for next < len(groupKeys) && answered[next] && results[next].err == nil && !results[next].isMember {
+			next++
+		}
+
+		if next < len(groupKeys) && answered[next] {
+			if results[next].err != nil {
+				return nil, results[next].err
+			}
+
+			return getGroups(ctx, service, groupKeys[next:next+1])
+		}
+	}
+
+	memberKeys := []string{}
+	for i, r := range results {
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		if r.isMember {
+			memberKeys = append(memberKeys, groupKeys[i])
+		}
+	}
+
+	return getGroups(ctx, service, memberKeys)
+}
+
+// getGroups gets the groups, concurrently, and returns them in the order of the keys. Several keys (e.g. an email
+// address and an alias) can refer to the same group, which is returned once; groups deleted meanwhile are skipped.
+func getGroups(ctx context.Context, service *directory.Service, groupKeys []string) ([]*googleGroup, error) {
+	type result struct {
+		group *directory.Group
+		err   error
+	}
+
+	results := make([]result, len(groupKeys))
+
+	var wg sync.WaitGroup
+	for i, groupKey := range groupKeys {
+		wg.Add(1)
+		go func(i int, groupKey string) {
+			defer wg.Done()
+
+			group, err := service.Groups.Get(groupKey).Context(ctx).Do()
+			if isGoogleAPIError(err, http.StatusNotFound) {
+				return
+			}
+
+			results[i] = result{group: group, err: err}
+		}(i, groupKey)
+	}
+
+	wg.Wait()
+
+	groups := []*googleGroup{}
+	found := map[string]bool{}
+	for _, r := range results {
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		if r.group != nil && !found[r.group.Id] {
+			found[r.group.Id] = true
+			groups = append(groups, directoryGroup(r.group))
+		}
+	}
+
+	return groups, nil
+}
+
+// hasGroupRole tells whether the user is a direct member of one of the groups, holding one of the membership roles
+// bound to it.
+func (c *googleOAuth) hasGroupRole(email string, groupRoles map[string][]string) (bool, error) {
+	if c.ServiceAccount == "" {
+		return false, fmt.Errorf("group membership roles cannot be checked without a service account")
+	}
+
+	ctx := context.Background()
+	service, err := c.directoryService(ctx, directoryGroupScope)
+	if err != nil {
+		return false, err
+	}
+
+	for groupKey, roles := range groupRoles {
+		member, err := service.Members.Get(groupKey, email).Context(ctx).Do()
+		if isGoogleAPIError(err, http.StatusNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		if sliceContains([]string{strings.ToUpper(member.Role)}, roles) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// resolveTransitiveGroups walks up the group hierarchy, level by level, from the groups the user is a direct member
+// of. Each group is visited once, so membership loops end the walk, which stops anyway after maxDepth levels.
+func resolveTransitiveGroups(ctx context.Context, service *directory.Service, email string, maxDepth int) ([]*googleGroup, error) {
+	groups := []*googleGroup{}
+	visited := map[string]bool{}
+	members := []string{email}
+
+	for depth := 0; depth < maxDepth && len(members) > 0; depth++ {
+		next := []string{}
+
+		for _, member := range members {
+			parents, err := resolveGroups(ctx, service, member)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, parent := range parents {
+				if visited[parent.ID] {
+					continue
+				}
+
+				visited[parent.ID] = true
+				groups = append(groups, parent)
+				next = append(next, parent.Email)
+			}
+		}
+
+		members = next
+	}
+
+	return groups, nil
+}
+
+// resolveGroups lists every group the user (or group) is a direct member of, following all the pages of the listing.
+func resolveGroups(ctx context.Context, service *directory.Service, email string) ([]*googleGroup, error) {
+	groups := []*googleGroup{}
+
+	err := service.Groups.List().UserKey(email).Pages(ctx, func(page *directory.Groups) error {
+		for _, g := range page.Groups {
+			groups = append(groups, directoryGroup(g))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
@@ -0,0 +1,140 @@
+package gaccauth
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestGroupMatches(t *testing.T) {
+	group := &googleGroup{ID: "group-eng", Email: "eng@example.com", Aliases: []string{"engineering@example.com"}}
+
+	for key, expected := range map[string]bool{
+		"eng@example.com":         true,
+		"ENG@Example.com":         true,
+		"engineering@example.com": true,
+		"group-eng":               true,
+		"GROUP-ENG":               false,
+		"ops@example.com":         false,
+	} {
+		if group.matches(key) != expected {
+			t.Errorf("matches(%q) should be %t", key, expected)
+		}
+	}
+}
+
+func TestGroups_MatchByAliasAndID(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("alias", map[string]interface{}{"bound_groups": "Engineering@example.com", "policies": "dev"})
+	e.writeRole("id", map[string]interface{}{"bound_groups": "group-eng", "policies": "dev"})
+
+	e.loginOK("alice@example.com", "alias")
+	e.loginOK("alice@example.com", "id")
+	e.loginFails("carol@example.com", "id")
+}
+
+func TestGroups_AllPages(t *testing.T) {
+	// more groups than fit in a page of the Directory
+	fixture := strings.Builder{}
+	fixture.WriteString("users:\n  - email: dana@example.com\ngroups:\n")
+	for i := 1; i <= 450; i++ {
+		fmt.Fprintf(&fixture, "  - email: team-%d@example.com\n    members:\n      - email: dana@example.com\n", i)
+	}
+
+	e := newTestEnvWithFixture(t, fixture.String())
+	e.writeRole("last", map[string]interface{}{"bound_groups": "team-450@example.com", "policies": "dev"})
+
+	auth := e.loginOK("dana@example.com", "last")
+	if len(auth.GroupAliases) != 450 {
+		t.Fatalf("expected 450 groups; got %d", len(auth.GroupAliases))
+	}
+}
+
+func TestGroups_Transitive(t *testing.T) {
+	e := newTestEnv(t)
+	// bob is in eng@ through platform@
+	e.writeRole("direct", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev"})
+	e.writeRole("nested", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev", "transitive_groups": true})
+
+	e.loginFails("bob@gmail.com", "direct")
+	e.loginOK("bob@gmail.com", "nested")
+
+	e.writeConfig(map[string]interface{}{pathConfigTransitiveGroupsProp: true})
+	e.loginOK("bob@gmail.com", "direct")
+
+	e.writeConfig(map[string]interface{}{pathConfigTransitiveGroupsProp: true, pathConfigMaxGroupDepthProp: 1})
+	e.loginFails("bob@gmail.com", "direct")
+
+	e.fails(logical.UpdateOperation, pathConfigPattern, e.configData(map[string]interface{}{pathConfigMaxGroupDepthProp: 0}))
+}
+
+func TestGroups_TransitiveCycle(t *testing.T) {
+	e := newTestEnvWithFixture(t, `
+users:
+  - email: erin@example.com
+groups:
+  - email: a@example.com
+    members:
+      - email: erin@example.com
+      - email: c@example.com
+  - email: b@example.com
+    members:
+      - email: a@example.com
+  - email: c@example.com
+    members:
+      - email: b@example.com
+`)
+	e.writeRole("c", map[string]interface{}{"bound_groups": "c@example.com", "policies": "dev", "transitive_groups": true})
+
+	auth := e.loginOK("erin@example.com", "c")
+	if len(auth.GroupAliases) != 3 {
+		t.Fatalf("expected 3 groups; got %v", groupAliasNames(auth))
+	}
+}
+
+func TestGroups_CheckMode(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeConfig(map[string]interface{}{pathConfigGroupLookupProp: groupLookupCheck})
+	e.writeRole("both", map[string]interface{}{"bound_groups": "eng@example.com,ops@example.com,engineering@example.com", "policies": "dev"})
+	e.writeRole("all", map[string]interface{}{"bound_groups": "eng@example.com,ops@example.com", "group_match_mode": groupMatchAll, "policies": "dev"})
+	e.writeRole("nested", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev"})
+
+	// the checks are decided in the order of the role's groups, so the result is the same on every login and renewal:
+	// roles needing a single membership stop at the first group the user is a member of, the others check them all
+	for i := 0; i < 5; i++ {
+		auth := e.loginOK("alice@example.com", "both")
+		if names := groupAliasNames(auth); !strutil.EquivalentSlices(names, []string{"eng@example.com"}) {
+			t.Fatalf("unexpected group aliases %v", names)
+		}
+
+		e.renewOK(auth)
+
+		if names := groupAliasNames(e.loginOK("carol@example.com", "both")); !strutil.EquivalentSlices(names, []string{"ops@example.com"}) {
+			t.Fatalf("unexpected group aliases %v", names)
+		}
+
+		if names := groupAliasNames(e.loginOK("alice@example.com", "all")); !strutil.EquivalentSlices(names, []string{"eng@example.com", "ops@example.com"}) {
+			t.Fatalf("unexpected group aliases %v", names)
+		}
+	}
+
+	// checks follow nested groups, and only the groups of the role are returned
+	if names := groupAliasNames(e.loginOK("bob@gmail.com", "nested")); !strutil.EquivalentSlices(names, []string{"eng@example.com"}) {
+		t.Fatalf("unexpected group aliases %v", names)
+	}
+
+	e.loginFails("carol@example.com", "nested")
+}
+
+func TestGroups_CheckModeDeletedGroup(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeConfig(map[string]interface{}{pathConfigGroupLookupProp: groupLookupCheck})
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com,gone@example.com", "denied_groups": "deleted@example.com", "policies": "dev"})
+
+	// groups unknown to the Directory have no members, rather than failing the login
+	e.loginOK("alice@example.com", "eng")
+	e.loginFails("carol@example.com", "eng")
+}
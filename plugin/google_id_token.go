@@ -0,0 +1,131 @@
+package gaccauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	goauth "google.golang.org/api/oauth2/v2"
+)
+
+// googleIssuers are the "iss" values Google may set on the ID tokens it signs.
+var googleIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
+// getKeySet returns the cached JWKS found at the given URL. Keys are fetched on first use and refreshed whenever a
+// token is signed by a key that is not in the cache, which covers Google's key rotation.
+func (b *googleAccountAuthBackend) getKeySet(jwksURL string) oidc.KeySet {
+	b.keySetLock.Lock()
+	defer b.keySetLock.Unlock()
+
+	if b.keySet == nil || b.keySetURL != jwksURL {
+		b.keySet = oidc.NewRemoteKeySet(context.Background(), jwksURL)
+		b.keySetURL = jwksURL
+	}
+
+	return b.keySet
+}
+
+// verifyIDToken checks the signature, issuer, audience and expiration of a Google-signed ID token and returns its
+// claims. Only tokens with a verified email address are accepted.
+func (b *googleAccountAuthBackend) verifyIDToken(ctx context.Context, googleOAuth *googleOAuth, rawIDToken string) (GenericMap, error) {
+	verifier := oidc.NewVerifier("", b.getKeySet(stringOrDefault(googleOAuth.JWKSURL, googleJWKSURL)), &oidc.Config{
+		SkipClientIDCheck: true,
+		SkipIssuerCheck:   true,
+	})
+
+	token, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("unable to verify ID token: %s", err)
+	}
+
+	if !sliceContains([]string{token.Issuer}, googleIssuers) {
+		return nil, fmt.Errorf("ID token issuer '%s' is not Google", token.Issuer)
+	}
+
+	if !sliceContains(token.Audience, googleOAuth.audiences()) {
+		return nil, fmt.Errorf("ID token audience is not allowed")
+	}
+
+	claims := GenericMap{}
+	if err := token.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		return nil, fmt.Errorf("ID token email address is not verified")
+	}
+
+	return claims, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////
+
+// userinfoClaims maps the userinfo response onto the claim names used by Google ID tokens.
+func userinfoClaims(user *goauth.Userinfo) GenericMap {
+	claims := GenericMap{
+		"sub":         user.Id,
+		"email":       user.Email,
+		"hd":          user.Hd,
+		"name":        user.Name,
+		"given_name":  user.GivenName,
+		"family_name": user.FamilyName,
+		"picture":     user.Picture,
+		"locale":      user.Locale,
+	}
+
+	if user.VerifiedEmail != nil {
+		claims["email_verified"] = *user.VerifiedEmail
+	}
+
+	return claims
+}
+
+// claimsUserinfo is the inverse of userinfoClaims.
+func claimsUserinfo(claims GenericMap) (*goauth.Userinfo, error) {
+	buf, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &goauth.Userinfo{}
+	if err := json.Unmarshal(buf, user); err != nil {
+		return nil, err
+	}
+
+	if sub, ok := claims["sub"].(string); ok {
+		user.Id = sub
+	}
+
+	if verified, ok := claims["email_verified"].(bool); ok {
+		user.VerifiedEmail = &verified
+	}
+
+	return user, nil
+}
+
+// claimsExpiry returns the expiration time of the ID token the claims come from.
+func claimsExpiry(claims GenericMap) (time.Time, bool) {
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(exp), 0), true
+}
+
+// claimMatches tells whether the claim, either a single value or a list of values, holds the expected value.
+func claimMatches(claim interface{}, expected string) bool {
+	if values, ok := claim.([]interface{}); ok {
+		for _, v := range values {
+			if fmt.Sprint(v) == expected {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return claim != nil && fmt.Sprint(claim) == expected
+}
@@ -1,17 +1,76 @@
 package gaccauth
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/vault/sdk/logical"
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
+	goauth "google.golang.org/api/oauth2/v2"
+)
+
+// Default Google endpoints, used unless overridden in the configuration.
+const (
+	googleAuthURL       = "https://accounts.google.com/o/oauth2/auth"
+	googleTokenURL      = "https://oauth2.googleapis.com/token"
+	googleDeviceAuthURL = "https://oauth2.googleapis.com/device/code"
+	googleUserinfoURL   = "https://www.googleapis.com/oauth2/v2/userinfo"
+	googleDirectoryURL  = "https://admin.googleapis.com/"
+	googleJWKSURL       = "https://www.googleapis.com/oauth2/v3/certs"
+)
+
+// Ways the groups of the user are looked up: listing all their groups, or checking the groups the role refers to.
+const (
+	groupLookupList  = "list"
+	groupLookupCheck = "check"
+)
+
+// defaultMaxGroupDepth bounds the nesting followed when resolving transitive group memberships.
+const defaultMaxGroupDepth = 10
+
+// Identities the entity alias of a login can be named after.
+const (
+	aliasSourceEmail  = "email"
+	aliasSourceUserID = "user_id"
+)
+
+// Identities the group aliases of a login can be named after.
+const (
+	groupAliasSourceEmail   = "email"
+	groupAliasSourceGroupID = "group_id"
 )
 
 type googleOAuth struct {
-	ClientID       string `json:"client_id"`
-	ClientSecret   string `json:"client_secret"`
-	RedirectURL    string `json:"redirect_url"`
-	FetchGroups    bool   `json:"fetch_groups"`
-	ServiceAccount string `json:"service_acc_key"`
-	DelegationUser string `json:"delegation_user"`
+	ClientID           string   `json:"client_id"`
+	ClientSecret       string   `json:"client_secret"`
+	DeviceClientID     string   `json:"device_client_id"`
+	DeviceClientSecret string   `json:"device_client_secret"`
+	RedirectURL        string   `json:"redirect_url"`
+	PublicClient       bool     `json:"public_client"`
+	FetchGroups        bool     `json:"fetch_groups"`
+	Transitive         bool     `json:"transitive_groups"`
+	MaxGroupDepth      int      `json:"max_group_depth"`
+	GroupLookup        string   `json:"group_lookup"`
+	ServiceAccount     string   `json:"service_acc_key"`
+	DelegationUser     string   `json:"delegation_user"`
+	BoundAudiences     []string `json:"bound_audiences"`
+	DefaultRole        string   `json:"default_role"`
+	AliasSource        string   `json:"alias_source"`
+	GroupAliasSource   string   `json:"group_alias_source"`
+	PolicySchemaField  string   `json:"policy_schema_field"`
+	PolicyGroupKey     string   `json:"policy_group_key"`
+	PolicyAllowlist    []string `json:"policy_allowlist"`
+	WebTitle           string   `json:"web_title"`
+	WebLogoURL         string   `json:"web_logo_url"`
+	WebHelpText        string   `json:"web_help_text"`
+	AuthURL            string   `json:"auth_url"`
+	TokenURL           string   `json:"token_url"`
+	DeviceAuthURL      string   `json:"device_auth_url"`
+	UserinfoURL        string   `json:"userinfo_url"`
+	DirectoryURL       string   `json:"directory_url"`
+	JWKSURL            string   `json:"jwks_url"`
 }
 
 func (c *googleOAuth) build() *oauth2.Config {
@@ -19,9 +78,125 @@ func (c *googleOAuth) build() *oauth2.Config {
 		ClientID:     c.ClientID,
 		ClientSecret: c.ClientSecret,
 		RedirectURL:  c.RedirectURL,
-		Endpoint:     google.Endpoint,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   stringOrDefault(c.AuthURL, googleAuthURL),
+			TokenURL:  stringOrDefault(c.TokenURL, googleTokenURL),
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
 		Scopes: []string{
 			"https://www.googleapis.com/auth/userinfo.email",
 		},
 	}
 }
+
+// audiences returns the client IDs ID tokens may be issued to; the mount's own client ID when none are configured.
+func (c *googleOAuth) audiences() []string {
+	if len(c.BoundAudiences) == 0 {
+		return []string{c.ClientID}
+	}
+
+	return c.BoundAudiences
+}
+
+// aliasSource returns the configured alias source; configurations written before it existed use the email address.
+func (c *googleOAuth) aliasSource() string {
+	return stringOrDefault(c.AliasSource, aliasSourceEmail)
+}
+
+// alias builds the entity alias of the user. The email address can change (e.g. when the account is renamed), so the
+// immutable user ID can be used instead to keep the user bound to the same entity.
+func (c *googleOAuth) alias(roleName string, user *goauth.Userinfo) *logical.Alias {
+	name := user.Email
+	if c.aliasSource() == aliasSourceUserID {
+		name = user.Id
+	}
+
+	return &logical.Alias{
+		Name: name,
+		Metadata: map[string]string{
+			"email": user.Email,
+			"hd":    user.Hd,
+			"name":  user.Name,
+			"role":  roleName,
+		},
+	}
+}
+
+// groupAliasSource returns the configured group alias source, the group email address unless set.
+func (c *googleOAuth) groupAliasSource() string {
+	return stringOrDefault(c.GroupAliasSource, groupAliasSourceEmail)
+}
+
+// groupAliases maps the groups of the user onto group aliases, which tie them to Vault external identity groups.
+func (c *googleOAuth) groupAliases(groups []*googleGroup) []*logical.Alias {
+	aliases := make([]*logical.Alias, 0, len(groups))
+
+	for _, group := range groups {
+		name := group.Email
+		if c.groupAliasSource() == groupAliasSourceGroupID {
+			name = group.ID
+		}
+
+		aliases = append(aliases, &logical.Alias{Name: name})
+	}
+
+	return aliases
+}
+
+// groupLookup returns the configured group lookup mode, listing unless set.
+func (c *googleOAuth) groupLookup() string {
+	return stringOrDefault(c.GroupLookup, groupLookupList)
+}
+
+// maxGroupDepth returns the configured nesting depth, the default one when unset.
+func (c *googleOAuth) maxGroupDepth() int {
+	if c.MaxGroupDepth < 1 {
+		return defaultMaxGroupDepth
+	}
+
+	return c.MaxGroupDepth
+}
+
+// authCodeURL builds the Google OAuth flow URL for a state handed out by the backend.
+func (c *googleOAuth) authCodeURL(stateID string, state *oauthState, opts ...oauth2.AuthCodeOption) string {
+	config := c.build()
+	config.RedirectURL = state.RedirectURI
+
+	opts = append(
+		opts,
+		oauth2.AccessTypeOffline,
+		oauth2.ApprovalForce,
+		oauth2.SetAuthURLParam("code_challenge", state.codeChallenge()),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	)
+
+	return config.AuthCodeURL(stateID, opts...)
+}
+
+// exchange trades an authorization code for a token, using the redirect URI and PKCE code verifier kept in the state.
+func (c *googleOAuth) exchange(ctx context.Context, code string, state *oauthState) (*oauth2.Token, error) {
+	config := c.build()
+	config.RedirectURL = state.RedirectURI
+
+	return config.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", state.CodeVerifier))
+}
+
+// fetchUserinfo reads the profile of the user the client is authorized as.
+func (c *googleOAuth) fetchUserinfo(client *http.Client) (*goauth.Userinfo, error) {
+	res, err := client.Get(stringOrDefault(c.UserinfoURL, googleUserinfoURL))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response from the userinfo endpoint: %s", res.Status)
+	}
+
+	user := &goauth.Userinfo{}
+	if err := json.NewDecoder(res.Body).Decode(user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
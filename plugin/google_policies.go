@@ -0,0 +1,142 @@
+package gaccauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// splitSchemaField splits a custom schema field reference, e.g. 'VaultAccess.policies', into the schema and field
+// names.
+func splitSchemaField(schemaField string) (string, string, error) {
+	parts := strings.Split(schemaField, ".")
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+		return "", "", fmt.Errorf("'%s' is not of the form 'schema.field'", schemaField)
+	}
+
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), nil
+}
+
+// schemaFieldValues returns the values of a custom schema field, which is either single-valued (a comma separated
+// list is accepted) or multi-valued.
+func schemaFieldValues(raw json.RawMessage) []string {
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return strings.Split(single, ",")
+	}
+
+	var multi []struct {
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &multi); err == nil {
+		values := []string{}
+		for _, v := range multi {
+			values = append(values, v.Value)
+		}
+
+		return values
+	}
+
+	return []string{}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+
+// directoryPolicies returns the policies declared for the user in the Directory: in the configured custom schema
+// field of their account, and in the configured line of the descriptions of their groups. Only the policies matching
+// the allowlist of the mount are returned, and never the root policy.
+func (c *googleOAuth) directoryPolicies(email string, groups []*googleGroup) ([]string, error) {
+	if c.ServiceAccount == "" || len(c.PolicyAllowlist) == 0 {
+		return []string{}, nil
+	}
+
+	declared := []string{}
+
+	if c.PolicySchemaField != "" {
+		policies, err := c.userDeclaredPolicies(email)
+		if err != nil {
+			return nil, err
+		}
+
+		declared = append(declared, policies...)
+	}
+
+	if c.PolicyGroupKey != "" {
+		declared = append(declared, c.groupDeclaredPolicies(groups)...)
+	}
+
+	policies := []string{}
+	for _, policy := range declared {
+		policy = strings.ToLower(strings.TrimSpace(policy))
+		if policy == "" || validatePolicies([]string{policy}) != nil {
+			continue
+		}
+
+		if matchesAnyPattern([]string{policy}, c.PolicyAllowlist) {
+			policies = append(policies, policy)
+		}
+	}
+
+	return policies, nil
+}
+
+// userDeclaredPolicies reads the policies in the custom schema field of the user's Directory account.
+func (c *googleOAuth) userDeclaredPolicies(email string) ([]string, error) {
+	schema, field, err := splitSchemaField(c.PolicySchemaField)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	service, err := c.directoryService(ctx, directoryUserScope)
+	if err != nil {
+		return nil, err
+	}
+
+	// accounts of other customers, out of reach of the service account, declare no policies
+	user, err := service.Users.Get(email).Projection("custom").CustomFieldMask(schema).Context(ctx).Do()
+	if isGoogleAPIError(err, http.StatusNotFound) || c.directoryOutOfReach(err, email) {
+		return []string{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	rawSchema, ok := user.CustomSchemas[schema]
+	if !ok {
+		return []string{}, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(rawSchema, &fields); err != nil {
+		return nil, fmt.Errorf("error reading custom schema '%s': %s", schema, err)
+	}
+
+	rawField, ok := fields[field]
+	if !ok {
+		return []string{}, nil
+	}
+
+	return schemaFieldValues(rawField), nil
+}
+
+// groupDeclaredPolicies reads the policies declared in the descriptions of the groups, on a line made of the
+// configured key followed by a colon and comma separated policies, e.g. 'vault-policies: dev,ops'. Groups have no
+// custom schemas, and the labels of Cloud Identity groups hold no values, but their description is free text admins
+// can edit, and is returned along with the groups of the user.
+func (c *googleOAuth) groupDeclaredPolicies(groups []*googleGroup) []string {
+	policies := []string{}
+	for _, group := range groups {
+		for _, line := range strings.Split(group.Description, "\n") {
+			key, value, ok := strings.Cut(line, ":")
+			if ok && strings.EqualFold(strings.TrimSpace(key), c.PolicyGroupKey) {
+				policies = append(policies, strings.Split(value, ",")...)
+			}
+		}
+	}
+
+	return policies
+}
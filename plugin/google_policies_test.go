@@ -0,0 +1,98 @@
+package gaccauth
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const testDeclaredPoliciesFixture = `
+users:
+  - id: "1001"
+    email: alice@example.com
+    hd: example.com
+    custom_schemas:
+      VaultAccess:
+        policies: "dev, team-eng,root,admin"
+  - id: "1003"
+    email: carol@example.com
+    hd: example.com
+    custom_schemas:
+      VaultAccess:
+        policies:
+          - value: team-ops
+          - value: team-sre
+  - id: "1002"
+    email: bob@gmail.com
+groups:
+  - id: group-staff
+    email: staff@example.com
+    members:
+      - email: alice@example.com
+      - email: carol@example.com
+      - email: bob@gmail.com
+  - id: group-sre
+    email: sre@example.com
+    description: |
+      Site reliability engineers.
+      Vault-Policies: team-sre, admin
+      other-policies: team-other
+    members:
+      - email: platform@example.com
+  - id: group-platform
+    email: platform@example.com
+    description: "vault-policies: root"
+    members:
+      - email: carol@example.com
+`
+
+func TestDirectoryPolicies(t *testing.T) {
+	e := newTestEnvWithFixture(t, testDeclaredPoliciesFixture)
+	e.writeConfig(map[string]interface{}{
+		pathConfigPolicySchemaFieldProp: "VaultAccess.policies",
+		pathConfigPolicyAllowlistProp:   "dev,team-*,root",
+	})
+	e.writeRole("staff", map[string]interface{}{"bound_groups": "staff@example.com", "policies": "base"})
+
+	// admin is not allowed by the mount, and root is never granted
+	auth := e.loginOK("alice@example.com", "staff")
+	assertPolicies(t, auth, "base", "dev", "team-eng")
+	e.renewOK(auth)
+
+	assertPolicies(t, e.loginOK("carol@example.com", "staff"), "base", "team-ops", "team-sre")
+
+	// the Directory refuses to read accounts of other customers
+	assertPolicies(t, e.loginOK("bob@gmail.com", "staff"), "base")
+}
+
+func TestDirectoryPolicies_GroupDescriptions(t *testing.T) {
+	e := newTestEnvWithFixture(t, testDeclaredPoliciesFixture)
+	e.writeConfig(map[string]interface{}{
+		pathConfigPolicyGroupKeyProp:  "vault-policies",
+		pathConfigPolicyAllowlistProp: "team-*,root",
+	})
+	e.writeRole("staff", map[string]interface{}{"bound_groups": "staff@example.com", "policies": "base", "transitive_groups": true})
+
+	// carol is in sre@ through platform@; admin is not allowed by the mount, and root is never granted
+	auth := e.loginOK("carol@example.com", "staff")
+	assertPolicies(t, auth, "base", "team-sre")
+	e.renewOK(auth)
+
+	// with check lookups, only the groups of the role declare policies, all of them on every login
+	e.writeConfig(map[string]interface{}{
+		pathConfigPolicyGroupKeyProp:  "vault-policies",
+		pathConfigPolicyAllowlistProp: "team-*",
+		pathConfigGroupLookupProp:     groupLookupCheck,
+	})
+	e.writeRole("sre", map[string]interface{}{"bound_groups": "staff@example.com,sre@example.com", "policies": "base"})
+	assertPolicies(t, e.loginOK("carol@example.com", "sre"), "base", "team-sre")
+}
+
+func TestDirectoryPolicies_Config(t *testing.T) {
+	e := newTestEnvWithFixture(t, testDeclaredPoliciesFixture)
+
+	e.fails(logical.UpdateOperation, pathConfigPattern, e.configData(map[string]interface{}{pathConfigPolicySchemaFieldProp: "VaultAccess.policies"}))
+	e.fails(logical.UpdateOperation, pathConfigPattern, e.configData(map[string]interface{}{pathConfigPolicyGroupKeyProp: "vault-policies"}))
+	e.fails(logical.UpdateOperation, pathConfigPattern, e.configData(map[string]interface{}{pathConfigPolicySchemaFieldProp: "policies", pathConfigPolicyAllowlistProp: "dev"}))
+	e.fails(logical.UpdateOperation, pathConfigPattern, e.configData(map[string]interface{}{pathConfigPolicySchemaFieldProp: "VaultAccess.policies", pathConfigPolicyAllowlistProp: "/dev(/"}))
+}
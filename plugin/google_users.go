@@ -0,0 +1,72 @@
+package gaccauth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	directory "google.golang.org/api/admin/directory/v1"
+)
+
+const directoryUserScope = "https://www.googleapis.com/auth/admin.directory.user.readonly"
+
+// fetchDirectoryUser reads the Directory account of the user through the service account. Nil is returned when no
+// service account is configured or the account is unknown to the Directory, such as consumer accounts.
+func (c *googleOAuth) fetchDirectoryUser(email string) (*directory.User, error) {
+	if c.ServiceAccount == "" {
+		return nil, nil
+	}
+
+	ctx := context.Background()
+	service, err := c.directoryService(ctx, directoryUserScope)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := service.Users.Get(email).Context(ctx).Do()
+	if isGoogleAPIError(err, http.StatusNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// directoryOutOfReach tells whether the Directory refused to serve the account because it is not one of the
+// customer's, e.g. a consumer account: Google answers 403 for accounts outside the domain of the delegation user.
+// Other failures, such as a service account not delegated the scope asked for, are not mistaken for it.
+func (c *googleOAuth) directoryOutOfReach(err error, email string) bool {
+	return isGoogleAPIError(err, http.StatusForbidden) && !strings.EqualFold(emailDomain(email), emailDomain(c.DelegationUser))
+}
+
+// userAddresses returns the lower-cased email addresses of the user: the one Google reported and, when the user has
+// a Directory account, its primary address and all its aliases. The aliases of accounts out of reach of the Directory
+// are unknown, which is reported along with the address the user signed in with.
+func (c *googleOAuth) userAddresses(email string) ([]string, bool, error) {
+	addresses := []string{strings.ToLower(email)}
+
+	user, err := c.fetchDirectoryUser(email)
+	if c.directoryOutOfReach(err, email) {
+		return addresses, true, nil
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	if user == nil {
+		return addresses, false, nil
+	}
+
+	for _, address := range append(append([]string{user.PrimaryEmail}, user.Aliases...), user.NonEditableAliases...) {
+		address = strings.ToLower(address)
+		if address != "" && !sliceContains([]string{address}, addresses) {
+			addresses = append(addresses, address)
+		}
+	}
+
+	return addresses, false, nil
+}
@@ -0,0 +1,141 @@
+package gaccauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	oauthStatePrefix  = "state/"
+	oauthStateTimeout = 10 * time.Minute
+)
+
+// oauthState is the server-side record of a state value handed out by the code_url path. It binds the pending
+// authorization to the role and redirect URI it was requested for, keeps the PKCE code verifier away from the client
+// and can be consumed only once.
+type oauthState struct {
+	RoleName     string    `json:"role"`
+	RedirectURI  string    `json:"redirect_uri"`
+	CodeVerifier string    `json:"code_verifier"`
+	ClientNonce  string    `json:"client_nonce"`
+	Listener     string    `json:"listener"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+func (s *oauthState) expired() bool {
+	return time.Now().After(s.ExpiresAt)
+}
+
+// codeChallenge derives the S256 PKCE code challenge (RFC 7636) from the state's code verifier.
+func (s *oauthState) codeChallenge() string {
+	sum := sha256.Sum256([]byte(s.CodeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// createOAuthState stores the state, filling in its PKCE code verifier and expiration, and returns its value.
+func (b *googleAccountAuthBackend) createOAuthState(ctx context.Context, storage logical.Storage, state *oauthState) (string, error) {
+	id, err := generateRandomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	verifier, err := generateRandomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	state.CodeVerifier = verifier
+	state.ExpiresAt = time.Now().Add(oauthStateTimeout)
+
+	entry, err := logical.StorageEntryJSON(oauthStatePrefix+id, state)
+	if err != nil {
+		return "", err
+	}
+
+	if err := storage.Put(ctx, entry); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// getOAuthState fetches the given state without consuming it. A nil state is returned when it is unknown.
+func (b *googleAccountAuthBackend) getOAuthState(ctx context.Context, storage logical.Storage, id string) (*oauthState, error) {
+	if id == "" {
+		return nil, nil
+	}
+
+	entry, err := storage.Get(ctx, oauthStatePrefix+id)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var state oauthState
+	if err := entry.DecodeJSON(&state); err != nil {
+		return nil, fmt.Errorf("error reading state: %s", err)
+	}
+
+	return &state, nil
+}
+
+// consumeOAuthState fetches and deletes the given state. A nil state is returned when it is unknown (including when it
+// was already used); an expired state is deleted as well, but reported as an error.
+func (b *googleAccountAuthBackend) consumeOAuthState(ctx context.Context, storage logical.Storage, id string) (*oauthState, error) {
+	b.stateLock.Lock()
+	defer b.stateLock.Unlock()
+
+	state, err := b.getOAuthState(ctx, storage, id)
+	if err != nil || state == nil {
+		return nil, err
+	}
+
+	if err := storage.Delete(ctx, oauthStatePrefix+id); err != nil {
+		return nil, err
+	}
+
+	if state.expired() {
+		return nil, fmt.Errorf("state has expired")
+	}
+
+	return state, nil
+}
+
+// tidyOAuthStates removes states that were handed out but never used to login.
+func (b *googleAccountAuthBackend) tidyOAuthStates(ctx context.Context, storage logical.Storage) error {
+	b.stateLock.Lock()
+	defer b.stateLock.Unlock()
+
+	ids, err := storage.List(ctx, oauthStatePrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		entry, err := storage.Get(ctx, oauthStatePrefix+id)
+		if err != nil {
+			return err
+		}
+
+		if entry == nil {
+			continue
+		}
+
+		var state oauthState
+		if err := entry.DecodeJSON(&state); err != nil || state.expired() {
+			if err := storage.Delete(ctx, oauthStatePrefix+id); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -2,19 +2,31 @@ package gaccauth
 
 import (
 	"context"
+	"fmt"
+
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
-	"golang.org/x/oauth2"
 )
 
 const (
-	pathCodeUrlPattern = "code_url"
+	pathCodeUrlPattern         = "code_url"
+	pathCodeUrlRoleNameProp    = "role"
+	pathCodeUrlRedirectURIProp = "redirect_uri"
 )
 
 func pathCodeUrl(b *googleAccountAuthBackend) *framework.Path {
 	return &framework.Path{
 		Pattern: pathCodeUrlPattern,
-		Fields:  Schema{},
+		Fields: Schema{
+			pathCodeUrlRoleNameProp: {
+				Type:        framework.TypeString,
+				Description: "Name of the role the login will be attempted against. If set, the login must use the same role",
+			},
+			pathCodeUrlRedirectURIProp: {
+				Type:        framework.TypeString,
+				Description: "Google OAuth redirect URL. Defaults to the configured redirect URL",
+			},
+		},
 		Callbacks: ActionCallback{
 			logical.ReadOperation: b.pathCodeUrlRead,
 		},
@@ -31,9 +43,37 @@ func (b *googleAccountAuthBackend) pathCodeUrlRead(ctx context.Context, req *log
 		return logical.ErrorResponse("missing Google OAuth config"), nil
 	}
 
+	var role *googleAuthRole
+
+	roleName := data.Get(pathCodeUrlRoleNameProp).(string)
+	if roleName != "" {
+		role, err = b.getDecodedRole(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+
+		if role == nil {
+			return logical.ErrorResponse(fmt.Sprintf("role '%s' not found", roleName)), nil
+		}
+	}
+
+	redirectURI := data.Get(pathCodeUrlRedirectURIProp).(string)
+	if redirectURI == "" {
+		redirectURI = googleOAuth.RedirectURL
+	} else if !isValidUrl(redirectURI) {
+		return logical.ErrorResponse(fmt.Sprintf("property '%s' must be a valid URL; got '%s'", pathCodeUrlRedirectURIProp, redirectURI)), nil
+	}
+
+	state := &oauthState{RoleName: roleName, RedirectURI: redirectURI}
+	stateID, err := b.createOAuthState(ctx, req.Storage, state)
+	if err != nil {
+		return nil, err
+	}
+
 	response := &logical.Response{
 		Data: GenericMap{
-			"url": googleOAuth.build().AuthCodeURL("state", oauth2.AccessTypeOffline, oauth2.ApprovalForce),
+			"url":   googleOAuth.authCodeURL(stateID, state, role.authCodeOptions()...),
+			"state": stateID,
 		},
 	}
 
@@ -3,20 +3,43 @@ package gaccauth
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
 const (
-	pathConfigDelegationUserProp    = "delegation_user"
-	pathConfigFetchGroupsProp       = "fetch_groups"
-	pathConfigClientIDProp          = "client_id"
-	pathConfigRedirectURLProp       = "redirect_url"
-	pathConfigClientSecretProp      = "client_secret"
-	pathConfigServiceAccountKeyProp = "service_acc_key"
-	pathConfigEntry                 = "config"
-	pathConfigPattern               = "config"
+	pathConfigDelegationUserProp     = "delegation_user"
+	pathConfigBoundAudiencesProp     = "bound_audiences"
+	pathConfigDefaultRoleProp        = "default_role"
+	pathConfigAliasSourceProp        = "alias_source"
+	pathConfigGroupAliasSourceProp   = "group_alias_source"
+	pathConfigPolicySchemaFieldProp  = "policy_schema_field"
+	pathConfigPolicyGroupKeyProp     = "policy_group_key"
+	pathConfigPolicyAllowlistProp    = "policy_allowlist"
+	pathConfigWebTitleProp           = "web_title"
+	pathConfigWebLogoURLProp         = "web_logo_url"
+	pathConfigWebHelpTextProp        = "web_help_text"
+	pathConfigAuthURLProp            = "auth_url"
+	pathConfigTokenURLProp           = "token_url"
+	pathConfigDeviceAuthURLProp      = "device_auth_url"
+	pathConfigUserinfoURLProp        = "userinfo_url"
+	pathConfigDirectoryURLProp       = "directory_url"
+	pathConfigJWKSURLProp            = "jwks_url"
+	pathConfigFetchGroupsProp        = "fetch_groups"
+	pathConfigTransitiveGroupsProp   = "transitive_groups"
+	pathConfigMaxGroupDepthProp      = "max_group_depth"
+	pathConfigGroupLookupProp        = "group_lookup"
+	pathConfigClientIDProp           = "client_id"
+	pathConfigRedirectURLProp        = "redirect_url"
+	pathConfigClientSecretProp       = "client_secret"
+	pathConfigDeviceClientIDProp     = "device_client_id"
+	pathConfigDeviceClientSecretProp = "device_client_secret"
+	pathConfigPublicClientProp       = "public_client"
+	pathConfigServiceAccountKeyProp  = "service_acc_key"
+	pathConfigEntry                  = "config"
+	pathConfigPattern                = "config"
 )
 
 func pathConfig(b *googleAccountAuthBackend) *framework.Path {
@@ -31,6 +54,18 @@ func pathConfig(b *googleAccountAuthBackend) *framework.Path {
 				Type:        framework.TypeString,
 				Description: "Google OAuth client secret",
 			},
+			pathConfigDeviceClientIDProp: {
+				Type:        framework.TypeString,
+				Description: "Google OAuth client id of the device flow, of the 'TVs and Limited Input devices' type. Defaults to the client id",
+			},
+			pathConfigDeviceClientSecretProp: {
+				Type:        framework.TypeString,
+				Description: "Google OAuth client secret of the device flow",
+			},
+			pathConfigPublicClientProp: {
+				Type:        framework.TypeBool,
+				Description: "Whether the Google OAuth client is a public client, which relies on PKCE instead of a client secret",
+			},
 			pathConfigRedirectURLProp: {
 				Type:        framework.TypeString,
 				Description: "Google OAuth redirect URL",
@@ -39,6 +74,20 @@ func pathConfig(b *googleAccountAuthBackend) *framework.Path {
 				Type:        framework.TypeBool,
 				Description: "Whether Google groups should be fetched or not",
 			},
+			pathConfigTransitiveGroupsProp: {
+				Type:        framework.TypeBool,
+				Description: "Whether the groups the user is an indirect member of (through nested groups) are fetched for every role",
+			},
+			pathConfigMaxGroupDepthProp: {
+				Type:        framework.TypeInt,
+				Default:     defaultMaxGroupDepth,
+				Description: "Maximum nesting depth followed when fetching indirect group memberships",
+			},
+			pathConfigGroupLookupProp: {
+				Type:        framework.TypeString,
+				Default:     groupLookupList,
+				Description: "How the groups of the user are looked up; either 'list' (every group of the user) or 'check' (only the groups the role refers to, directly or through nested groups)",
+			},
 			pathConfigServiceAccountKeyProp: {
 				Type:        framework.TypeString,
 				Description: "Google service account key content",
@@ -47,6 +96,72 @@ func pathConfig(b *googleAccountAuthBackend) *framework.Path {
 				Type:        framework.TypeString,
 				Description: "Google delegation email address",
 			},
+			pathConfigBoundAudiencesProp: {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma separate list of Google OAuth client ids that ID tokens may be issued to. Defaults to the configured client id",
+			},
+			pathConfigDefaultRoleProp: {
+				Type:        framework.TypeString,
+				Description: "Role used by the oidc/auth_url path when none is provided",
+			},
+			pathConfigAliasSourceProp: {
+				Type:        framework.TypeString,
+				Default:     aliasSourceEmail,
+				Description: "Identity the entity alias is named after; either 'email' (primary email address) or 'user_id' (immutable Google user ID)",
+			},
+			pathConfigGroupAliasSourceProp: {
+				Type:        framework.TypeString,
+				Default:     groupAliasSourceEmail,
+				Description: "Identity group aliases are named after; either 'email' (group email address) or 'group_id' (immutable Google group ID)",
+			},
+			pathConfigPolicySchemaFieldProp: {
+				Type:        framework.TypeString,
+				Description: "Custom schema field of the users' Directory accounts declaring their policies, e.g. 'VaultAccess.policies'",
+			},
+			pathConfigPolicyGroupKeyProp: {
+				Type:        framework.TypeString,
+				Description: "Key of the line of the users' groups descriptions declaring their policies, e.g. 'vault-policies' for a line 'vault-policies: dev,ops'",
+			},
+			pathConfigPolicyAllowlistProp: {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma separate list of globs, or regular expressions enclosed in slashes, one of which policies declared in the Directory must match to be granted",
+			},
+			pathConfigWebTitleProp: {
+				Type:        framework.TypeString,
+				Description: "Title of the login pages served by the plugin",
+			},
+			pathConfigWebLogoURLProp: {
+				Type:        framework.TypeString,
+				Description: "URL of the logo shown on the login pages served by the plugin",
+			},
+			pathConfigWebHelpTextProp: {
+				Type:        framework.TypeString,
+				Description: "Help text shown on the login page served by the plugin",
+			},
+			pathConfigAuthURLProp: {
+				Type:        framework.TypeString,
+				Description: "Override of the Google OAuth authorization URL",
+			},
+			pathConfigTokenURLProp: {
+				Type:        framework.TypeString,
+				Description: "Override of the Google OAuth token URL",
+			},
+			pathConfigDeviceAuthURLProp: {
+				Type:        framework.TypeString,
+				Description: "Override of the Google OAuth device authorization URL",
+			},
+			pathConfigUserinfoURLProp: {
+				Type:        framework.TypeString,
+				Description: "Override of the Google userinfo URL",
+			},
+			pathConfigDirectoryURLProp: {
+				Type:        framework.TypeString,
+				Description: "Override of the Google Admin Directory API base URL",
+			},
+			pathConfigJWKSURLProp: {
+				Type:        framework.TypeString,
+				Description: "Override of the URL of the keys Google signs ID tokens with",
+			},
 		},
 		Callbacks: ActionCallback{
 			logical.UpdateOperation: b.pathConfigWrite,
@@ -57,8 +172,13 @@ func pathConfig(b *googleAccountAuthBackend) *framework.Path {
 
 func (b *googleAccountAuthBackend) pathConfigWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	gauthc := googleOAuth{
-		ServiceAccount: data.Get(pathConfigServiceAccountKeyProp).(string),
-		DelegationUser: data.Get(pathConfigDelegationUserProp).(string),
+		ServiceAccount:    data.Get(pathConfigServiceAccountKeyProp).(string),
+		DelegationUser:    data.Get(pathConfigDelegationUserProp).(string),
+		DefaultRole:       strings.ToLower(data.Get(pathConfigDefaultRoleProp).(string)),
+		PolicySchemaField: strings.TrimSpace(data.Get(pathConfigPolicySchemaFieldProp).(string)),
+		PolicyGroupKey:    strings.TrimSpace(data.Get(pathConfigPolicyGroupKeyProp).(string)),
+		WebTitle:          data.Get(pathConfigWebTitleProp).(string),
+		WebHelpText:       data.Get(pathConfigWebHelpTextProp).(string),
 	}
 
 	if clientID, err := getRequiredStringData(data, pathConfigClientIDProp); err == nil {
@@ -67,12 +187,28 @@ func (b *googleAccountAuthBackend) pathConfigWrite(ctx context.Context, req *log
 		return nil, err
 	}
 
-	if clientSecret, err := getRequiredStringData(data, pathConfigClientSecretProp); err == nil {
+	if publicClient, ok := data.GetOk(pathConfigPublicClientProp); ok {
+		gauthc.PublicClient = publicClient.(bool)
+	} else {
+		gauthc.PublicClient = false
+	}
+
+	if gauthc.PublicClient {
+		// public clients cannot keep a secret; PKCE protects the code exchange instead
+		gauthc.ClientSecret = strings.TrimSpace(data.Get(pathConfigClientSecretProp).(string))
+	} else if clientSecret, err := getRequiredStringData(data, pathConfigClientSecretProp); err == nil {
 		gauthc.ClientSecret = *clientSecret
 	} else {
 		return nil, err
 	}
 
+	gauthc.DeviceClientID = strings.TrimSpace(data.Get(pathConfigDeviceClientIDProp).(string))
+	gauthc.DeviceClientSecret = strings.TrimSpace(data.Get(pathConfigDeviceClientSecretProp).(string))
+
+	if gauthc.DeviceClientID != "" && gauthc.DeviceClientSecret == "" {
+		return nil, fmt.Errorf("property '%s' must be set along with '%s'", pathConfigDeviceClientSecretProp, pathConfigDeviceClientIDProp)
+	}
+
 	if redirectURL, err := getRequiredStringData(data, pathConfigRedirectURLProp); err == nil {
 		url := *redirectURL
 		if !isValidUrl(url) {
@@ -84,12 +220,100 @@ func (b *googleAccountAuthBackend) pathConfigWrite(ctx context.Context, req *log
 		return nil, err
 	}
 
+	if logoURL := data.Get(pathConfigWebLogoURLProp).(string); logoURL != "" {
+		if !isValidUrl(logoURL) {
+			return nil, fmt.Errorf("property '%s' must be a valid URL; got '%s'", pathConfigWebLogoURLProp, logoURL)
+		}
+
+		gauthc.WebLogoURL = logoURL
+	}
+
+	endpoints := map[string]*string{
+		pathConfigAuthURLProp:       &gauthc.AuthURL,
+		pathConfigTokenURLProp:      &gauthc.TokenURL,
+		pathConfigDeviceAuthURLProp: &gauthc.DeviceAuthURL,
+		pathConfigUserinfoURLProp:   &gauthc.UserinfoURL,
+		pathConfigDirectoryURLProp:  &gauthc.DirectoryURL,
+		pathConfigJWKSURLProp:       &gauthc.JWKSURL,
+	}
+
+	for prop, endpoint := range endpoints {
+		url := strings.TrimSpace(data.Get(prop).(string))
+		if url != "" && !isValidUrl(url) {
+			return nil, fmt.Errorf("property '%s' must be a valid URL; got '%s'", prop, url)
+		}
+
+		*endpoint = url
+	}
+
+	aliasSource := strings.ToLower(strings.TrimSpace(data.Get(pathConfigAliasSourceProp).(string)))
+	if aliasSource != aliasSourceEmail && aliasSource != aliasSourceUserID {
+		return nil, fmt.Errorf("property '%s' must be either '%s' or '%s'; got '%s'", pathConfigAliasSourceProp, aliasSourceEmail, aliasSourceUserID, aliasSource)
+	}
+
+	gauthc.AliasSource = aliasSource
+
+	groupAliasSource := strings.ToLower(strings.TrimSpace(data.Get(pathConfigGroupAliasSourceProp).(string)))
+	if groupAliasSource != groupAliasSourceEmail && groupAliasSource != groupAliasSourceGroupID {
+		return nil, fmt.Errorf("property '%s' must be either '%s' or '%s'; got '%s'", pathConfigGroupAliasSourceProp, groupAliasSourceEmail, groupAliasSourceGroupID, groupAliasSource)
+	}
+
+	gauthc.GroupAliasSource = groupAliasSource
+
 	if fetchGroups, ok := data.GetOk(pathConfigFetchGroupsProp); ok {
 		gauthc.FetchGroups = fetchGroups.(bool)
 	} else {
 		gauthc.FetchGroups = false
 	}
 
+	gauthc.Transitive = data.Get(pathConfigTransitiveGroupsProp).(bool)
+
+	groupLookup := strings.ToLower(strings.TrimSpace(data.Get(pathConfigGroupLookupProp).(string)))
+	if groupLookup != groupLookupList && groupLookup != groupLookupCheck {
+		return nil, fmt.Errorf("property '%s' must be either '%s' or '%s'; got '%s'", pathConfigGroupLookupProp, groupLookupList, groupLookupCheck, groupLookup)
+	}
+
+	gauthc.GroupLookup = groupLookup
+
+	if maxDepth, err := getPositiveIntData(data, pathConfigMaxGroupDepthProp); err == nil {
+		if maxDepth == nil {
+			gauthc.MaxGroupDepth = defaultMaxGroupDepth
+		} else {
+			gauthc.MaxGroupDepth = *maxDepth
+		}
+	} else {
+		return nil, fmt.Errorf("property '%s': %s", pathConfigMaxGroupDepthProp, err)
+	}
+
+	if gauthc.PolicySchemaField != "" {
+		if _, _, err := splitSchemaField(gauthc.PolicySchemaField); err != nil {
+			return nil, fmt.Errorf("property '%s': %s", pathConfigPolicySchemaFieldProp, err)
+		}
+	}
+
+	if policyAllowlist := getFilteredStringSliceData(data, pathConfigPolicyAllowlistProp); policyAllowlist != nil {
+		gauthc.PolicyAllowlist = *policyAllowlist
+	} else {
+		gauthc.PolicyAllowlist = []string{}
+	}
+
+	for _, pattern := range gauthc.PolicyAllowlist {
+		if _, err := compilePattern(pattern); err != nil {
+			return nil, fmt.Errorf("property '%s': invalid pattern '%s': %s", pathConfigPolicyAllowlistProp, pattern, err)
+		}
+	}
+
+	// policies declared in the Directory are only granted once the mount says which ones may be
+	if (gauthc.PolicySchemaField != "" || gauthc.PolicyGroupKey != "") && len(gauthc.PolicyAllowlist) == 0 {
+		return nil, fmt.Errorf("property '%s' must be set along with '%s' or '%s'", pathConfigPolicyAllowlistProp, pathConfigPolicySchemaFieldProp, pathConfigPolicyGroupKeyProp)
+	}
+
+	if boundAudiences := getFilteredStringSliceData(data, pathConfigBoundAudiencesProp); boundAudiences != nil {
+		gauthc.BoundAudiences = *boundAudiences
+	} else {
+		gauthc.BoundAudiences = []string{}
+	}
+
 	entry, err := logical.StorageEntryJSON(pathConfigEntry, gauthc)
 	if err != nil {
 		return nil, err
@@ -110,11 +334,32 @@ func (b *googleAccountAuthBackend) pathConfigRead(ctx context.Context, req *logi
 
 	response := &logical.Response{
 		Data: GenericMap{
-			/* client secret and service account key are not returned for security reasons */
-			pathConfigClientIDProp:       googleOAuth.ClientID,
-			pathConfigRedirectURLProp:    googleOAuth.RedirectURL,
-			pathConfigFetchGroupsProp:    googleOAuth.FetchGroups,
-			pathConfigDelegationUserProp: googleOAuth.DelegationUser,
+			/* client secrets and service account key are not returned for security reasons */
+			pathConfigClientIDProp:          googleOAuth.ClientID,
+			pathConfigDeviceClientIDProp:    googleOAuth.DeviceClientID,
+			pathConfigRedirectURLProp:       googleOAuth.RedirectURL,
+			pathConfigPublicClientProp:      googleOAuth.PublicClient,
+			pathConfigFetchGroupsProp:       googleOAuth.FetchGroups,
+			pathConfigTransitiveGroupsProp:  googleOAuth.Transitive,
+			pathConfigMaxGroupDepthProp:     googleOAuth.maxGroupDepth(),
+			pathConfigGroupLookupProp:       googleOAuth.groupLookup(),
+			pathConfigDelegationUserProp:    googleOAuth.DelegationUser,
+			pathConfigBoundAudiencesProp:    googleOAuth.BoundAudiences,
+			pathConfigDefaultRoleProp:       googleOAuth.DefaultRole,
+			pathConfigAliasSourceProp:       googleOAuth.aliasSource(),
+			pathConfigGroupAliasSourceProp:  googleOAuth.groupAliasSource(),
+			pathConfigPolicySchemaFieldProp: googleOAuth.PolicySchemaField,
+			pathConfigPolicyGroupKeyProp:    googleOAuth.PolicyGroupKey,
+			pathConfigPolicyAllowlistProp:   googleOAuth.PolicyAllowlist,
+			pathConfigWebTitleProp:          googleOAuth.WebTitle,
+			pathConfigWebLogoURLProp:        googleOAuth.WebLogoURL,
+			pathConfigWebHelpTextProp:       googleOAuth.WebHelpText,
+			pathConfigAuthURLProp:           googleOAuth.AuthURL,
+			pathConfigTokenURLProp:          googleOAuth.TokenURL,
+			pathConfigDeviceAuthURLProp:     googleOAuth.DeviceAuthURL,
+			pathConfigUserinfoURLProp:       googleOAuth.UserinfoURL,
+			pathConfigDirectoryURLProp:      googleOAuth.DirectoryURL,
+			pathConfigJWKSURLProp:           googleOAuth.JWKSURL,
 		},
 	}
 
@@ -0,0 +1,73 @@
+package gaccauth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathDeviceCodePattern      = "device_code"
+	pathDeviceCodeRoleNameProp = "role"
+)
+
+func pathDeviceCode(b *googleAccountAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: pathDeviceCodePattern,
+		Fields: Schema{
+			pathDeviceCodeRoleNameProp: {
+				Type:        framework.TypeString,
+				Description: "Name of the role the login will be attempted against. If set, the login must use the same role",
+			},
+		},
+		Callbacks: ActionCallback{
+			logical.ReadOperation: b.pathDeviceCodeRead,
+		},
+	}
+}
+
+func (b *googleAccountAuthBackend) pathDeviceCodeRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	googleOAuth, err := b.getGoogleOAuthConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if googleOAuth == nil {
+		return logical.ErrorResponse("missing Google OAuth config"), nil
+	}
+
+	roleName := data.Get(pathDeviceCodeRoleNameProp).(string)
+	if roleName != "" {
+		role, err := b.getDecodedRole(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+
+		if role == nil {
+			return logical.ErrorResponse(fmt.Sprintf("role '%s' not found", roleName)), nil
+		}
+	}
+
+	auth, err := googleOAuth.deviceClient().startDeviceAuthorization(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.createDeviceState(ctx, req.Storage, auth.DeviceCode, roleName, auth.ExpiresIn); err != nil {
+		return nil, err
+	}
+
+	response := &logical.Response{
+		Data: GenericMap{
+			"device_code":      auth.DeviceCode,
+			"user_code":        auth.UserCode,
+			"verification_url": auth.VerificationURL,
+			"expires_in":       auth.ExpiresIn,
+			"interval":         auth.Interval,
+		},
+	}
+
+	return response, nil
+}
@@ -1,22 +1,27 @@
 package gaccauth
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
-	directory "google.golang.org/api/admin/directory/v1"
 	goauth "google.golang.org/api/oauth2/v2"
 
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/policyutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
 const (
 	pathLoginPattern            = "login"
 	pathLoginGoogleAuthCodeProp = "code"
+	pathLoginStateProp          = "state"
+	pathLoginIDTokenProp        = "id_token"
+	pathLoginDeviceCodeProp     = "device_code"
 	pathLoginRoleNameProp       = "role"
 )
 
@@ -28,6 +33,18 @@ func pathLogin(b *googleAccountAuthBackend) *framework.Path {
 				Type:        framework.TypeString,
 				Description: "Google authentication code",
 			},
+			pathLoginStateProp: {
+				Type:        framework.TypeString,
+				Description: "State returned by the code_url path along with the Google OAuth flow URL",
+			},
+			pathLoginIDTokenProp: {
+				Type:        framework.TypeString,
+				Description: "Google-signed OIDC ID token, used instead of an authentication code",
+			},
+			pathLoginDeviceCodeProp: {
+				Type:        framework.TypeString,
+				Description: "Device code returned by the device_code path, used instead of an authentication code",
+			},
 			pathLoginRoleNameProp: {
 				Type:        framework.TypeString,
 				Description: "Name of the role against which the login is being attempted",
@@ -35,13 +52,21 @@ func pathLogin(b *googleAccountAuthBackend) *framework.Path {
 		},
 		Callbacks: ActionCallback{
 			logical.UpdateOperation:         b.pathLoginAuthFlow,
-			logical.AliasLookaheadOperation: b.pathLoginAuthFlow,
+			logical.AliasLookaheadOperation: b.pathLoginAliasLookahead,
 		},
 	}
 }
 
+// loginError is a failed login attributable to the client, reported back as an error response.
+type loginError struct {
+	message string
+}
+
+func (e *loginError) Error() string {
+	return e.message
+}
+
 func (b *googleAccountAuthBackend) pathLoginAuthFlow(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	code := data.Get(pathLoginGoogleAuthCodeProp).(string)
 	roleName := data.Get(pathLoginRoleNameProp).(string)
 	role, err := b.getDecodedRole(ctx, req.Storage, roleName)
 
@@ -63,18 +88,193 @@ func (b *googleAccountAuthBackend) pathLoginAuthFlow(ctx context.Context, req *l
 		return logical.ErrorResponse("missing config"), nil
 	}
 
-	googleConfig := googleOAuth.build()
-	token, err := googleConfig.Exchange(oauth2.NoContext, code)
+	if idToken := data.Get(pathLoginIDTokenProp).(string); idToken != "" {
+		return b.loginWithIDToken(ctx, req, googleOAuth, roleName, role, idToken)
+	}
+
+	var token *oauth2.Token
+	if deviceCode := data.Get(pathLoginDeviceCodeProp).(string); deviceCode != "" {
+		googleOAuth = googleOAuth.deviceClient()
+		token, err = b.deviceCodeToken(ctx, req.Storage, googleOAuth, roleName, deviceCode, true)
+	} else {
+		token, err = b.authCodeToken(ctx, req.Storage, googleOAuth, roleName, data, true)
+	}
+
+	if loginErr, ok := err.(*loginError); ok {
+		return logical.ErrorResponse(loginErr.Error()), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return b.loginWithToken(ctx, req, googleOAuth, roleName, role, token)
+}
+
+// pathLoginAliasLookahead resolves the entity alias of a login without completing it. The token obtained from Google
+// is kept for the login that follows, so the authorization code (or device code) is not spent by the lookahead.
+func (b *googleAccountAuthBackend) pathLoginAliasLookahead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get(pathLoginRoleNameProp).(string)
+
+	googleOAuth, err := b.getGoogleOAuthConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if googleOAuth == nil {
+		return logical.ErrorResponse("missing config"), nil
+	}
+
+	var user *goauth.Userinfo
+
+	if idToken := data.Get(pathLoginIDTokenProp).(string); idToken != "" {
+		claims, err := b.verifyIDToken(ctx, googleOAuth, idToken)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+
+		if user, err = claimsUserinfo(claims); err != nil {
+			return nil, err
+		}
+	} else {
+		var token *oauth2.Token
+		if deviceCode := data.Get(pathLoginDeviceCodeProp).(string); deviceCode != "" {
+			googleOAuth = googleOAuth.deviceClient()
+			token, err = b.deviceCodeToken(ctx, req.Storage, googleOAuth, roleName, deviceCode, false)
+		} else {
+			token, err = b.authCodeToken(ctx, req.Storage, googleOAuth, roleName, data, false)
+		}
+
+		if loginErr, ok := err.(*loginError); ok {
+			return logical.ErrorResponse(loginErr.Error()), nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		client := googleOAuth.build().Client(ctx, token)
+		if user, err = googleOAuth.fetchUserinfo(client); err != nil {
+			return nil, err
+		}
+	}
+
+	response := &logical.Response{
+		Auth: &logical.Auth{
+			Alias: googleOAuth.alias(roleName, user),
+		},
+	}
+
+	return response, nil
+}
+
+// authCodeToken exchanges the authorization code of the login for a token. The login state is consumed only when
+// consume is set; the token is then taken from the exchange cache when an alias lookahead already obtained it.
+func (b *googleAccountAuthBackend) authCodeToken(ctx context.Context, storage logical.Storage, googleOAuth *googleOAuth, roleName string, data *framework.FieldData, consume bool) (*oauth2.Token, error) {
+	code := data.Get(pathLoginGoogleAuthCodeProp).(string)
+	stateID := data.Get(pathLoginStateProp).(string)
+	if stateID == "" {
+		return nil, &loginError{"missing state"}
+	}
+
+	var state *oauthState
+	var err error
+
+	if consume {
+		state, err = b.consumeOAuthState(ctx, storage, stateID)
+		if err != nil {
+			return nil, &loginError{err.Error()}
+		}
+	} else {
+		state, err = b.getOAuthState(ctx, storage, stateID)
+		if err != nil {
+			return nil, err
+		}
+
+		if state != nil && state.expired() {
+			return nil, &loginError{"state has expired"}
+		}
+	}
+
+	if state == nil {
+		return nil, &loginError{"invalid state"}
+	}
+
+	if state.RoleName != "" && state.RoleName != roleName {
+		return nil, &loginError{fmt.Sprintf("state was issued for role '%s'", state.RoleName)}
+	}
+
+	cacheKey := exchangeCacheKey("code", code)
+	if token := b.cachedExchange(cacheKey, consume); token != nil {
+		return token, nil
+	}
+
+	token, err := googleOAuth.exchange(ctx, code, state)
 	if err != nil {
 		return nil, err
 	}
 
-	user, groups, err := b.authenticate(googleOAuth, token)
+	if !consume {
+		b.cacheExchange(cacheKey, token)
+	}
+
+	return token, nil
+}
+
+// deviceCodeToken polls Google for the token of a device authorization. The pending authorization is deleted only
+// when consume is set, once the user approved or denied it, or it expired; it is kept on any other failure, such as
+// Google being unreachable, so the login can be retried.
+func (b *googleAccountAuthBackend) deviceCodeToken(ctx context.Context, storage logical.Storage, googleOAuth *googleOAuth, roleName string, deviceCode string, consume bool) (*oauth2.Token, error) {
+	state, err := b.getDeviceState(ctx, storage, deviceCode)
+	if err != nil {
+		return nil, &loginError{err.Error()}
+	}
+
+	if state == nil {
+		return nil, &loginError{"invalid device code"}
+	}
+
+	if state.RoleName != "" && state.RoleName != roleName {
+		return nil, &loginError{fmt.Sprintf("device code was issued for role '%s'", state.RoleName)}
+	}
+
+	cacheKey := exchangeCacheKey("device", deviceCode)
+	token := b.cachedExchange(cacheKey, consume)
+
+	if token == nil {
+		token, err = googleOAuth.pollDeviceToken(ctx, deviceCode)
+		if tokenErr, ok := err.(*deviceTokenError); ok && tokenErr.pending() {
+			// the user has not approved the authorization yet; the client is expected to retry
+			return nil, &loginError{tokenErr.Code}
+		}
+	}
+
+	tokenErr, isTokenErr := err.(*deviceTokenError)
+
+	if !consume {
+		if err == nil {
+			b.cacheExchange(cacheKey, token)
+		}
+	} else if err == nil || (isTokenErr && tokenErr.terminal()) {
+		if err := b.deleteDeviceState(ctx, storage, deviceCode); err != nil {
+			return nil, err
+		}
+	}
+
+	if isTokenErr {
+		return nil, &loginError{err.Error()}
+	}
+
+	return token, err
+}
+
+func (b *googleAccountAuthBackend) loginWithToken(ctx context.Context, req *logical.Request, googleOAuth *googleOAuth, roleName string, role *googleAuthRole, token *oauth2.Token) (*logical.Response, error) {
+	user, groups, err := b.authenticate(googleOAuth, role, token)
 	if err != nil {
 		return nil, err
 	}
 
-	policies, err := b.authorize(req.Storage, role, user, groups)
+	policies, err := b.authorize(ctx, req.Storage, googleOAuth, role, user, groups, userinfoClaims(user))
 	if err != nil {
 		return logical.ErrorResponse(err.Error()), nil
 	}
@@ -84,35 +284,77 @@ func (b *googleAccountAuthBackend) pathLoginAuthFlow(ctx context.Context, req *l
 		return nil, err
 	}
 
-	response := &logical.Response{
-		Auth: &logical.Auth{
-			DisplayName: user.Email,
-			Policies:    policies,
-			InternalData: GenericMap{
-				"token": encodedToken,
-				"role":  roleName,
-			},
-			Metadata: map[string]string{
-				"username": user.Email,
-			},
-			LeaseOptions: logical.LeaseOptions{
-				TTL:       role.TTL,
-				Renewable: true,
-			},
-		},
+	// the token can only be refreshed by the OAuth client it was issued to
+	return b.loginResponse(googleOAuth, roleName, role, user, groups, policies, GenericMap{"token": encodedToken, "client_id": googleOAuth.ClientID})
+}
+
+func (b *googleAccountAuthBackend) loginWithIDToken(ctx context.Context, req *logical.Request, googleOAuth *googleOAuth, roleName string, role *googleAuthRole, idToken string) (*logical.Response, error) {
+	claims, err := b.verifyIDToken(ctx, googleOAuth, idToken)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
 	}
 
-	return response, nil
+	user, err := claimsUserinfo(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := b.fetchGroups(googleOAuth, role, user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	policies, err := b.authorize(ctx, req.Storage, googleOAuth, role, user, groups, claims)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	encodedClaims, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.loginResponse(googleOAuth, roleName, role, user, groups, policies, GenericMap{"claims": string(encodedClaims)})
+	if err != nil {
+		return nil, err
+	}
+
+	// ID tokens cannot be refreshed, so the Vault token must not outlive the one it was issued for
+	if expiresAt, ok := claimsExpiry(claims); ok {
+		remaining := time.Until(expiresAt)
+		if resp.Auth.ExplicitMaxTTL == 0 || remaining < resp.Auth.ExplicitMaxTTL {
+			resp.Auth.ExplicitMaxTTL = remaining
+		}
+	}
+
+	return resp, nil
 }
 
-///////////////////////////////////////////////////////////////////////////////
+func (b *googleAccountAuthBackend) loginResponse(googleOAuth *googleOAuth, roleName string, role *googleAuthRole, user *goauth.Userinfo, groups []*googleGroup, policies []string, internalData GenericMap) (*logical.Response, error) {
+	internalData["role"] = roleName
 
-func (b *googleAccountAuthBackend) authRenew(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	encodedToken, ok := req.Auth.InternalData["token"].(string)
-	if !ok {
-		return nil, errors.New("no refresh token from previous login")
+	displayName, metadata, err := googleOAuth.tokenMetadata(roleName, role, user, groups)
+	if err != nil {
+		return nil, err
 	}
 
+	auth := &logical.Auth{
+		DisplayName:  displayName,
+		InternalData: internalData,
+		Alias:        googleOAuth.alias(roleName, user),
+		GroupAliases: googleOAuth.groupAliases(groups),
+		Metadata:     metadata,
+	}
+
+	role.PopulateTokenAuth(auth)
+	auth.Policies = policies
+
+	return &logical.Response{Auth: auth}, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////
+
+func (b *googleAccountAuthBackend) authRenew(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	roleName, ok := req.Auth.InternalData["role"].(string)
 	if !ok {
 		return nil, errors.New("no role name from previous login")
@@ -136,78 +378,196 @@ func (b *googleAccountAuthBackend) authRenew(ctx context.Context, req *logical.R
 		return logical.ErrorResponse("missing Google OAuth config"), nil
 	}
 
-	token, err := decodeToken(encodedToken)
-	if err != nil {
-		return nil, err
+	var user *goauth.Userinfo
+	var groups []*googleGroup
+	var claims GenericMap
+
+	if encodedToken, ok := req.Auth.InternalData["token"].(string); ok {
+		token, err := decodeToken(encodedToken)
+		if err != nil {
+			return nil, err
+		}
+
+		if clientID, _ := req.Auth.InternalData["client_id"].(string); clientID != "" && clientID == googleOAuth.DeviceClientID {
+			googleOAuth = googleOAuth.deviceClient()
+		}
+
+		user, groups, err = b.authenticate(googleOAuth, role, token)
+		if err != nil {
+			return nil, err
+		}
+
+		claims = userinfoClaims(user)
+	} else if encodedClaims, ok := req.Auth.InternalData["claims"].(string); ok {
+		// ID token logins cannot refresh the token, so the identity from the login is re-authorized as is, until the
+		// ID token expires
+		if err := json.Unmarshal([]byte(encodedClaims), &claims); err != nil {
+			return nil, err
+		}
+
+		if expiresAt, ok := claimsExpiry(claims); !ok || time.Now().After(expiresAt) {
+			return logical.ErrorResponse("ID token has expired; log in again"), nil
+		}
+
+		user, err = claimsUserinfo(claims)
+		if err != nil {
+			return nil, err
+		}
+
+		groups, err = b.fetchGroups(googleOAuth, role, user.Email)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, errors.New("no refresh token from previous login")
 	}
 
-	user, groups, err := b.authenticate(googleOAuth, token)
+	policies, err := b.authorize(ctx, req.Storage, googleOAuth, role, user, groups, claims)
 	if err != nil {
 		return nil, err
 	}
 
-	policies, err := b.authorize(req.Storage, role, user, groups)
-	if err != nil {
-		return nil, err
+	// the default policy is added by Vault to the token policies unless the role opts out of it
+	if !policyutil.EquivalentPolicies(policies, req.Auth.TokenPolicies) {
+		return logical.ErrorResponse(fmt.Sprintf("policies do not match. new policies: %s. old policies: %s.", policies, req.Auth.TokenPolicies)), nil
 	}
 
-	if !sliceEquals(policies, req.Auth.Policies) {
-		return logical.ErrorResponse(fmt.Sprintf("policies do not match. new policies: %s. old policies: %s.", policies, req.Auth.Policies)), nil
-	}
+	resp := &logical.Response{Auth: req.Auth}
+	resp.Auth.TTL = role.TokenTTL
+	resp.Auth.MaxTTL = role.TokenMaxTTL
+	resp.Auth.Period = role.TokenPeriod
 
-	return framework.LeaseExtend(role.TTL, role.MaxTTL, b.System())(ctx, req, d)
+	// memberships may have changed since the login; Vault updates the external groups of the entity accordingly
+	resp.Auth.GroupAliases = googleOAuth.groupAliases(groups)
+
+	return resp, nil
 }
 
-func (b *googleAccountAuthBackend) authenticate(googleOAuth *googleOAuth, token *oauth2.Token) (*goauth.Userinfo, []string, error) {
+func (b *googleAccountAuthBackend) authenticate(googleOAuth *googleOAuth, role *googleAuthRole, token *oauth2.Token) (*goauth.Userinfo, []*googleGroup, error) {
 	client := googleOAuth.build().Client(context.Background(), token)
 
-	userService, err := goauth.New(client)
+	user, err := googleOAuth.fetchUserinfo(client)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	user, err := goauth.NewUserinfoV2MeService(userService).Get().Do()
+	groups, err := b.fetchGroups(googleOAuth, role, user.Email)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	groups := []string{}
+	return user, groups, nil
+}
 
-	if !googleOAuth.FetchGroups {
-		return user, groups, nil
+func (b *googleAccountAuthBackend) authorize(ctx context.Context, storage logical.Storage, googleOAuth *googleOAuth, role *googleAuthRole, user *goauth.Userinfo, groups []*googleGroup, claims GenericMap) ([]string, error) {
+	if err := role.validateWithConfig(googleOAuth); err != nil {
+		return nil, err
 	}
 
-	saScopes := "https://www.googleapis.com/auth/admin.directory.group.readonly"
-	saCredential, err := google.JWTConfigFromJSON([]byte(googleOAuth.ServiceAccount), saScopes)
-	if err != nil {
-		return nil, nil, err
+	// the Directory is only asked for the aliases of the user when the role refers to email addresses
+	addresses := []string{strings.ToLower(user.Email)}
+	if len(role.BoundEmails)+len(role.DeniedEmails)+len(role.BoundEmailPatterns) > 0 {
+		var outOfReach bool
+		var err error
+		if addresses, outOfReach, err = googleOAuth.userAddresses(user.Email); err != nil {
+			return nil, err
+		}
+
+		// a denied alias of the user could not be told apart from none
+		if outOfReach && len(role.DeniedEmails) > 0 {
+			return nil, fmt.Errorf("the addresses of '%s' cannot be checked against the denied emails of this role", user.Email)
+		}
 	}
 
-	saCredential.Subject = googleOAuth.DelegationUser
-	saClient, err := directory.New(saCredential.Client(context.Background()))
-	if err != nil {
-		return nil, nil, err
+	// deny rules win over every binding of the role
+	for _, deniedEmail := range role.DeniedEmails {
+		if sliceContains([]string{strings.ToLower(deniedEmail)}, addresses) {
+			return nil, fmt.Errorf("user '%s' is denied by this role", deniedEmail)
+		}
 	}
 
-	response, err := saClient.Groups.List().UserKey(user.Email).Do()
-	if err != nil {
-		return nil, nil, err
+	for _, deniedGroup := range role.DeniedGroups {
+		if groupsMatch(groups, []string{deniedGroup}) {
+			return nil, fmt.Errorf("members of group '%s' are denied by this role", deniedGroup)
+		}
 	}
 
-	for _, g := range response.Groups {
-		groups = append(groups, g.Email)
+	for name, value := range role.BoundClaims {
+		if !claimMatches(claims[name], value) {
+			return nil, fmt.Errorf("claim '%s' does not match the value bound to this role", name)
+		}
 	}
 
-	return user, groups, nil
-}
+	hostedDomain, _ := claims["hd"].(string)
+	if hostedDomain == "" && (role.RejectConsumerAccounts || len(role.BoundHostedDomains) > 0) {
+		return nil, fmt.Errorf("consumer Google accounts are not allowed to use this role")
+	}
+
+	if len(role.BoundHostedDomains) > 0 && !sliceContains([]string{strings.ToLower(hostedDomain)}, role.BoundHostedDomains) {
+		return nil, fmt.Errorf("domain '%s' is not allowed to use this role", hostedDomain)
+	}
+
+	if !groupsMatchAll(groups, role.RequiredGroups) {
+		return nil, fmt.Errorf("user is not a member of all the groups required by this role")
+	}
+
+	isGroupMember := groupsMatch(groups, role.BoundGroups)
+	if role.groupMatchMode() == groupMatchAll {
+		isGroupMember = len(role.BoundGroups) > 0 && groupsMatchAll(groups, role.BoundGroups)
+	}
+
+	isUserMember := sliceContains(addresses, lowerAll(role.BoundEmails))
+	isPatternMember := matchesAnyPattern(addresses, role.BoundEmailPatterns) ||
+		matchesAnyPattern(groupAddresses(groups), role.BoundGroupPatterns)
+
+	// a role only bound by its required groups admits all their members
+	isOnlyRequired := role.bindingCount() == 0
 
-func (b *googleAccountAuthBackend) authorize(storage logical.Storage, role *googleAuthRole, user *goauth.Userinfo, groups []string) ([]string, error) {
-	isGroupMember := sliceContains(groups, role.BoundGroups)
-	isUserMember := sliceContains([]string{user.Email}, role.BoundEmails)
+	if isUserMember || isGroupMember || isPatternMember || isOnlyRequired {
+		return b.grantedPolicies(ctx, storage, googleOAuth, role, user, addresses, groups)
+	}
+
+	if len(role.BoundGroupRoles) > 0 {
+		hasGroupRole, err := googleOAuth.hasGroupRole(user.Email, role.BoundGroupRoles)
+		if err != nil {
+			return nil, err
+		}
 
-	if isUserMember || isGroupMember {
-		return role.Policies, nil
+		if hasGroupRole {
+			return b.grantedPolicies(ctx, storage, googleOAuth, role, user, addresses, groups)
+		}
 	}
 
 	return nil, fmt.Errorf("user is not allowed to use this role")
 }
+
+// grantedPolicies returns the policies of a user allowed to use the role: the role's own policies and the ones mapped
+// to the user and their groups, or only the latter when the role says so, plus the ones derived from their groups and
+// the ones declared for them in the Directory.
+func (b *googleAccountAuthBackend) grantedPolicies(ctx context.Context, storage logical.Storage, googleOAuth *googleOAuth, role *googleAuthRole, user *goauth.Userinfo, addresses []string, groups []*googleGroup) ([]string, error) {
+	policies, err := mappedPolicies(ctx, storage, addresses, groups)
+	if err != nil {
+		return nil, err
+	}
+
+	if role.mappedPolicies() == mappedPoliciesAppend {
+		policies = append(policies, role.TokenPolicies...)
+	}
+
+	policies = append(policies, role.groupPolicies(groups)...)
+
+	directoryPolicies, err := googleOAuth.directoryPolicies(user.Email, groups)
+	if err != nil {
+		return nil, err
+	}
+
+	policies = append(policies, directoryPolicies...)
+	policies = policyutil.SanitizePolicies(policies, false)
+
+	// a role granting mapped policies instead of its own would otherwise issue tokens with the default policy alone
+	if len(policies) == 0 && role.mappedPolicies() == mappedPoliciesReplace {
+		return nil, fmt.Errorf("no policies are mapped to user '%s' or their groups", user.Email)
+	}
+
+	return policies, nil
+}
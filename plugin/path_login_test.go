@@ -0,0 +1,694 @@
+package gaccauth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestLogin_CodeFlow(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev"})
+
+	auth := e.loginOK("alice@example.com", "eng")
+	assertPolicies(t, auth, "dev")
+
+	if auth.DisplayName != "alice@example.com" {
+		t.Fatalf("unexpected display name %q", auth.DisplayName)
+	}
+
+	if auth.InternalData["role"] != "eng" {
+		t.Fatalf("unexpected internal data %v", auth.InternalData)
+	}
+
+	// bob is in no group bound to the role
+	e.loginFails("bob@gmail.com", "eng")
+}
+
+func TestLogin_UnknownRole(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev"})
+
+	data := e.authorize("alice@example.com", "eng")
+	data[pathLoginRoleNameProp] = "nope"
+	e.fails(logical.UpdateOperation, pathLoginPattern, data)
+}
+
+func TestLogin_State(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev"})
+	e.writeRole("ops", map[string]interface{}{"bound_groups": "ops@example.com", "policies": "ops"})
+
+	t.Run("missing", func(t *testing.T) {
+		data := e.authorize("alice@example.com", "eng")
+		delete(data, pathLoginStateProp)
+		e.fails(logical.UpdateOperation, pathLoginPattern, data)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		data := e.authorize("alice@example.com", "eng")
+		data[pathLoginStateProp] = "forged"
+		e.fails(logical.UpdateOperation, pathLoginPattern, data)
+	})
+
+	t.Run("reused", func(t *testing.T) {
+		data := e.authorize("alice@example.com", "eng")
+		e.ok(logical.UpdateOperation, pathLoginPattern, data)
+		e.fails(logical.UpdateOperation, pathLoginPattern, data)
+	})
+
+	t.Run("other role", func(t *testing.T) {
+		data := e.authorize("alice@example.com", "eng")
+		data[pathLoginRoleNameProp] = "ops"
+		e.fails(logical.UpdateOperation, pathLoginPattern, data)
+	})
+}
+
+func TestLogin_PKCE(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeConfig(map[string]interface{}{pathConfigClientSecretProp: nil, pathConfigPublicClientProp: true})
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev"})
+
+	resp := e.ok(logical.ReadOperation, pathCodeUrlPattern, map[string]interface{}{pathCodeUrlRoleNameProp: "eng"})
+	if !strings.Contains(resp.Data["url"].(string), "code_challenge_method=S256") {
+		t.Fatalf("code_url has no PKCE challenge: %s", resp.Data["url"])
+	}
+
+	// the emulator rejects code exchanges whose verifier does not match the challenge
+	assertPolicies(t, e.loginOK("alice@example.com", "eng"), "dev")
+}
+
+func TestLogin_AliasLookahead(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev"})
+
+	data := e.authorize("alice@example.com", "eng")
+
+	resp := e.ok(logical.AliasLookaheadOperation, pathLoginPattern, data)
+	if resp.Auth.Alias.Name != "alice@example.com" {
+		t.Fatalf("unexpected alias %v", resp.Auth.Alias)
+	}
+
+	// the lookahead neither consumes the state nor spends the code
+	e.ok(logical.UpdateOperation, pathLoginPattern, data)
+}
+
+func TestLogin_IDToken(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev", "token_period": "24h"})
+	e.writeRole("claims", map[string]interface{}{"bound_emails": "alice@example.com", "policies": "dev", "bound_claims": "hd=example.com"})
+
+	resp := e.ok(logical.UpdateOperation, pathLoginPattern, map[string]interface{}{
+		pathLoginIDTokenProp:  e.idToken("alice@example.com", testClientID),
+		pathLoginRoleNameProp: "eng",
+	})
+	assertPolicies(t, resp.Auth, "dev")
+
+	// the token cannot outlive the ID token, even when periodic
+	if resp.Auth.ExplicitMaxTTL <= 0 || resp.Auth.ExplicitMaxTTL > time.Hour {
+		t.Fatalf("unexpected explicit max TTL %s", resp.Auth.ExplicitMaxTTL)
+	}
+
+	e.renewOK(resp.Auth)
+
+	expired := *resp.Auth
+	expired.InternalData = map[string]interface{}{
+		"role":   "eng",
+		"claims": `{"sub":"1001","email":"alice@example.com","email_verified":true,"exp":1}`,
+	}
+	e.renewFails(&expired)
+
+	e.fails(logical.UpdateOperation, pathLoginPattern, map[string]interface{}{
+		pathLoginIDTokenProp:  e.idToken("alice@example.com", "another-client"),
+		pathLoginRoleNameProp: "eng",
+	})
+
+	e.fails(logical.UpdateOperation, pathLoginPattern, map[string]interface{}{
+		pathLoginIDTokenProp:  "not.a.token",
+		pathLoginRoleNameProp: "eng",
+	})
+
+	e.ok(logical.UpdateOperation, pathLoginPattern, map[string]interface{}{
+		pathLoginIDTokenProp:  e.idToken("alice@example.com", testClientID),
+		pathLoginRoleNameProp: "claims",
+	})
+
+	e.writeRole("claims", map[string]interface{}{"bound_emails": "alice@example.com", "policies": "dev", "bound_claims": "hd=other.com"})
+	e.fails(logical.UpdateOperation, pathLoginPattern, map[string]interface{}{
+		pathLoginIDTokenProp:  e.idToken("alice@example.com", testClientID),
+		pathLoginRoleNameProp: "claims",
+	})
+}
+
+func TestLogin_IDTokenAudiences(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeConfig(map[string]interface{}{pathConfigBoundAudiencesProp: "cli-client,gcloud"})
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev"})
+
+	e.ok(logical.UpdateOperation, pathLoginPattern, map[string]interface{}{
+		pathLoginIDTokenProp:  e.idToken("alice@example.com", "gcloud"),
+		pathLoginRoleNameProp: "eng",
+	})
+
+	// once audiences are bound, the mount's own client is no longer implied
+	e.fails(logical.UpdateOperation, pathLoginPattern, map[string]interface{}{
+		pathLoginIDTokenProp:  e.idToken("alice@example.com", testClientID),
+		pathLoginRoleNameProp: "eng",
+	})
+}
+
+func TestLogin_DeviceFlow(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev"})
+	e.writeRole("ops", map[string]interface{}{"bound_groups": "ops@example.com", "policies": "ops"})
+
+	deviceCode, userCode := e.startDevice("eng")
+	data := map[string]interface{}{pathLoginDeviceCodeProp: deviceCode, pathLoginRoleNameProp: "eng"}
+
+	resp, err := e.request(logical.UpdateOperation, pathLoginPattern, data)
+	if err != nil || !resp.IsError() || resp.Error().Error() != "authorization_pending" {
+		t.Fatalf("expected a pending login; got %v %v", resp, err)
+	}
+
+	e.fails(logical.UpdateOperation, pathLoginPattern, map[string]interface{}{pathLoginDeviceCodeProp: deviceCode, pathLoginRoleNameProp: "ops"})
+
+	e.approveDevice(userCode, "alice@example.com")
+	assertPolicies(t, e.ok(logical.UpdateOperation, pathLoginPattern, data).Auth, "dev")
+
+	// the device code is spent
+	e.fails(logical.UpdateOperation, pathLoginPattern, data)
+}
+
+func TestLogin_DeviceClient(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev"})
+
+	e.fails(logical.UpdateOperation, pathConfigPattern, e.configData(map[string]interface{}{pathConfigDeviceClientIDProp: "tv-client"}))
+
+	deviceConfig := map[string]interface{}{pathConfigDeviceClientIDProp: "tv-client", pathConfigDeviceClientSecretProp: "tv-secret"}
+	e.writeConfig(deviceConfig)
+
+	// the emulator only hands the token to the client the device authorization was started by
+	deviceCode, userCode := e.startDevice("eng")
+	e.approveDevice(userCode, "alice@example.com")
+	auth := e.ok(logical.UpdateOperation, pathLoginPattern, map[string]interface{}{pathLoginDeviceCodeProp: deviceCode, pathLoginRoleNameProp: "eng"}).Auth
+
+	if auth.InternalData["client_id"] != "tv-client" {
+		t.Fatalf("unexpected internal data %v", auth.InternalData)
+	}
+
+	e.renewOK(auth)
+
+	// browser logins keep using the mount's client
+	assertPolicies(t, e.loginOK("alice@example.com", "eng"), "dev")
+
+	// a failure that is not the end of the authorization keeps the device code usable
+	deviceCode, userCode = e.startDevice("eng")
+	e.approveDevice(userCode, "alice@example.com")
+	data := map[string]interface{}{pathLoginDeviceCodeProp: deviceCode, pathLoginRoleNameProp: "eng"}
+
+	e.writeConfig(map[string]interface{}{pathConfigDeviceClientIDProp: "other-client", pathConfigDeviceClientSecretProp: "other-secret"})
+	e.fails(logical.UpdateOperation, pathLoginPattern, data)
+
+	e.writeConfig(deviceConfig)
+	e.ok(logical.UpdateOperation, pathLoginPattern, data)
+}
+
+func TestRenew(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev", "ttl": "10m", "max_ttl": "1h"})
+
+	auth := e.loginOK("alice@example.com", "eng")
+
+	renewed := e.renewOK(auth)
+	if renewed.TTL != 10*time.Minute || renewed.MaxTTL != time.Hour {
+		t.Fatalf("unexpected TTLs %s and %s", renewed.TTL, renewed.MaxTTL)
+	}
+
+	// the role changed since the login
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev,ops"})
+	e.renewFails(auth)
+
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "ops@example.com", "policies": "dev"})
+	e.renewOK(auth)
+
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "platform@example.com", "policies": "dev"})
+	e.renewFails(auth)
+
+	e.ok(logical.DeleteOperation, "role/eng", nil)
+	e.renewFails(auth)
+}
+
+func TestLogin_RoleBindings(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("emails", map[string]interface{}{"bound_emails": "bob@gmail.com", "policies": "bob"})
+	e.writeRole("groups", map[string]interface{}{"bound_groups": "ops@example.com,platform@example.com", "policies": "dev"})
+
+	assertPolicies(t, e.loginOK("bob@gmail.com", "emails"), "bob")
+	e.loginFails("alice@example.com", "emails")
+
+	e.loginOK("alice@example.com", "groups")
+	e.loginOK("bob@gmail.com", "groups")
+	e.loginOK("carol@example.com", "groups")
+}
+
+func TestLogin_GroupsNotFetched(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeConfig(map[string]interface{}{pathConfigFetchGroupsProp: false})
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev"})
+	e.writeRole("alice", map[string]interface{}{"bound_emails": "alice@example.com", "policies": "dev"})
+
+	e.loginFails("alice@example.com", "eng")
+	e.loginOK("alice@example.com", "alice")
+}
+
+func TestLogin_HostedDomain(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("domain", map[string]interface{}{"bound_emails": "alice@example.com,bob@gmail.com", "policies": "dev", "bound_hosted_domains": "Example.com"})
+	e.writeRole("domains", map[string]interface{}{"bound_emails": "alice@example.com,bob@gmail.com", "policies": "dev", "bound_hosted_domains": "example.com,example.org"})
+	e.writeRole("workspace", map[string]interface{}{"bound_emails": "alice@example.com,bob@gmail.com", "policies": "dev", "reject_consumer_accounts": true})
+
+	// Google is hinted at the domain only when there is a single one
+	resp := e.ok(logical.ReadOperation, pathCodeUrlPattern, map[string]interface{}{pathCodeUrlRoleNameProp: "domain"})
+	if !strings.Contains(resp.Data["url"].(string), "hd=example.com") {
+		t.Fatalf("code_url has no hosted domain hint: %s", resp.Data["url"])
+	}
+
+	resp = e.ok(logical.ReadOperation, pathCodeUrlPattern, map[string]interface{}{pathCodeUrlRoleNameProp: "domains"})
+	if strings.Contains(resp.Data["url"].(string), "hd=") {
+		t.Fatalf("code_url has a hosted domain hint: %s", resp.Data["url"])
+	}
+
+	e.loginOK("alice@example.com", "domain")
+	e.loginFails("bob@gmail.com", "domain")
+	e.loginOK("alice@example.com", "domains")
+	e.loginFails("bob@gmail.com", "domains")
+	e.loginOK("alice@example.com", "workspace")
+	e.loginFails("bob@gmail.com", "workspace")
+
+	// ID tokens are checked against their hd claim
+	e.ok(logical.UpdateOperation, pathLoginPattern, map[string]interface{}{
+		pathLoginIDTokenProp:  e.idToken("alice@example.com", testClientID),
+		pathLoginRoleNameProp: "domain",
+	})
+	e.fails(logical.UpdateOperation, pathLoginPattern, map[string]interface{}{
+		pathLoginIDTokenProp:  e.idToken("bob@gmail.com", testClientID),
+		pathLoginRoleNameProp: "workspace",
+	})
+}
+
+func TestLogin_EntityAlias(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev"})
+
+	alias := e.loginOK("alice@example.com", "eng").Alias
+	if alias.Name != "alice@example.com" {
+		t.Fatalf("unexpected alias %v", alias)
+	}
+
+	for key, expected := range map[string]string{"email": "alice@example.com", "hd": "example.com", "name": "Alice Example", "role": "eng"} {
+		if alias.Metadata[key] != expected {
+			t.Fatalf("unexpected alias metadata %v", alias.Metadata)
+		}
+	}
+
+	// the immutable user ID survives renames of the account
+	e.writeConfig(map[string]interface{}{pathConfigAliasSourceProp: aliasSourceUserID})
+	if alias := e.loginOK("alice@example.com", "eng").Alias; alias.Name != "1001" {
+		t.Fatalf("unexpected alias %v", alias)
+	}
+
+	resp := e.ok(logical.AliasLookaheadOperation, pathLoginPattern, map[string]interface{}{
+		pathLoginIDTokenProp:  e.idToken("alice@example.com", testClientID),
+		pathLoginRoleNameProp: "eng",
+	})
+	if resp.Auth.Alias.Name != "1001" {
+		t.Fatalf("unexpected alias %v", resp.Auth.Alias)
+	}
+
+	// the lookahead of a device login does not spend the device code
+	deviceCode, userCode := e.startDevice("eng")
+	e.approveDevice(userCode, "alice@example.com")
+	data := map[string]interface{}{pathLoginDeviceCodeProp: deviceCode, pathLoginRoleNameProp: "eng"}
+	e.ok(logical.AliasLookaheadOperation, pathLoginPattern, data)
+	e.ok(logical.UpdateOperation, pathLoginPattern, data)
+
+	e.fails(logical.UpdateOperation, pathConfigPattern, e.configData(map[string]interface{}{pathConfigAliasSourceProp: "name"}))
+}
+
+func groupAliasNames(auth *logical.Auth) []string {
+	names := []string{}
+	for _, alias := range auth.GroupAliases {
+		names = append(names, alias.Name)
+	}
+
+	return names
+}
+
+func TestLogin_GroupAliases(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev"})
+
+	auth := e.loginOK("alice@example.com", "eng")
+	if names := groupAliasNames(auth); !strutil.EquivalentSlices(names, []string{"eng@example.com", "ops@example.com"}) {
+		t.Fatalf("unexpected group aliases %v", names)
+	}
+
+	e.writeConfig(map[string]interface{}{pathConfigGroupAliasSourceProp: groupAliasSourceGroupID})
+	auth = e.loginOK("alice@example.com", "eng")
+	if names := groupAliasNames(auth); !strutil.EquivalentSlices(names, []string{"group-eng", "group-ops"}) {
+		t.Fatalf("unexpected group aliases %v", names)
+	}
+
+	// renewals report the memberships of the moment
+	if names := groupAliasNames(e.renewOK(auth)); !strutil.EquivalentSlices(names, []string{"group-eng", "group-ops"}) {
+		t.Fatalf("unexpected group aliases %v", names)
+	}
+
+	e.fails(logical.UpdateOperation, pathConfigPattern, e.configData(map[string]interface{}{pathConfigGroupAliasSourceProp: "name"}))
+}
+
+func TestLogin_GroupRoles(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("owners", map[string]interface{}{"bound_group_roles": map[string]interface{}{"eng@example.com": "OWNER|manager"}, "policies": "dev"})
+	e.writeRole("members", map[string]interface{}{"bound_group_roles": "ops@example.com=MEMBER", "policies": "dev"})
+
+	resp := e.ok(logical.ReadOperation, "role/owners", nil)
+	if roles := resp.Data["bound_group_roles"].(map[string][]string)["eng@example.com"]; !strutil.EquivalentSlices(roles, []string{"OWNER", "MANAGER"}) {
+		t.Fatalf("unexpected group roles %v", resp.Data["bound_group_roles"])
+	}
+
+	e.loginOK("alice@example.com", "owners")
+	// bob is only an indirect member of eng@
+	e.loginFails("bob@gmail.com", "owners")
+	e.loginOK("carol@example.com", "members")
+	e.loginFails("bob@gmail.com", "members")
+
+	e.fails(logical.UpdateOperation, "role/invalid", map[string]interface{}{"bound_group_roles": "ops@example.com=BOSS", "policies": "dev"})
+}
+
+func TestLogin_GroupMatchModes(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("all", map[string]interface{}{"bound_groups": "eng@example.com,ops@example.com", "group_match_mode": "all", "policies": "dev"})
+	e.writeRole("required", map[string]interface{}{"bound_emails": "alice@example.com,carol@example.com", "required_groups": "eng@example.com", "policies": "dev"})
+	e.writeRole("required-only", map[string]interface{}{"required_groups": "eng@example.com,ops@example.com", "policies": "dev"})
+
+	if mode := e.ok(logical.ReadOperation, "role/all", nil).Data["group_match_mode"]; mode != groupMatchAll {
+		t.Fatalf("unexpected group match mode %v", mode)
+	}
+
+	e.loginOK("alice@example.com", "all")
+	e.loginFails("carol@example.com", "all")
+	e.loginOK("alice@example.com", "required")
+	e.loginFails("carol@example.com", "required")
+	e.loginOK("alice@example.com", "required-only")
+	e.loginFails("carol@example.com", "required-only")
+
+	e.fails(logical.UpdateOperation, "role/invalid", map[string]interface{}{"bound_groups": "eng@example.com", "group_match_mode": "most", "policies": "dev"})
+}
+
+func TestLogin_Deny(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev", "transitive_groups": true})
+
+	auth := e.loginOK("bob@gmail.com", "eng")
+
+	// deny rules win at renewal too
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com", "denied_groups": "platform@example.com", "policies": "dev", "transitive_groups": true})
+	e.renewFails(auth)
+
+	resp, err := e.login("bob@gmail.com", "eng")
+	if err != nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "platform@example.com") {
+		t.Fatalf("expected a rejection naming the denied group; got %v %v", resp, err)
+	}
+
+	e.loginOK("alice@example.com", "eng")
+
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com", "denied_emails": "Alice@example.com", "policies": "dev"})
+	resp, err = e.login("alice@example.com", "eng")
+	if err != nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "alice@example.com") {
+		t.Fatalf("expected a rejection naming the denied user; got %v %v", resp, err)
+	}
+}
+
+func TestLogin_DenyNestedGroups(t *testing.T) {
+	e := newTestEnv(t)
+
+	e.writeRole("bob", map[string]interface{}{"bound_emails": "bob@gmail.com", "policies": "dev"})
+	e.loginOK("bob@gmail.com", "bob")
+
+	// bob is only in eng@ through platform@, which listing his direct groups does not show
+	e.writeRole("bob", map[string]interface{}{"bound_emails": "bob@gmail.com", "denied_groups": "eng@example.com", "policies": "dev"})
+	e.loginFails("bob@gmail.com", "bob")
+
+	e.writeConfig(map[string]interface{}{pathConfigGroupLookupProp: groupLookupCheck})
+	e.loginFails("bob@gmail.com", "bob")
+}
+
+func TestLogin_DenyWithoutGroups(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("alice", map[string]interface{}{"bound_emails": "alice@example.com", "denied_groups": "platform@example.com", "policies": "dev"})
+	e.loginOK("alice@example.com", "alice")
+
+	// denied groups cannot be told apart from no groups when they are not fetched, so the role is turned down
+	e.writeConfig(map[string]interface{}{pathConfigFetchGroupsProp: false})
+	e.loginFails("alice@example.com", "alice")
+	e.fails(logical.UpdateOperation, "role/carol", map[string]interface{}{"bound_emails": "carol@example.com", "denied_groups": "platform@example.com", "policies": "dev"})
+
+	e.writeRole("carol", map[string]interface{}{"bound_emails": "carol@example.com", "denied_emails": "alice@example.com", "policies": "dev"})
+	e.loginOK("carol@example.com", "carol")
+}
+
+func TestLogin_Patterns(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("emails", map[string]interface{}{"bound_email_patterns": "*@Example.com", "policies": "dev"})
+	e.writeRole("groups", map[string]interface{}{"bound_group_patterns": "/plat.*@example\\.com/", "policies": "dev"})
+	e.writeRole("aliases", map[string]interface{}{"bound_group_patterns": "engineer*@example.com", "policies": "dev"})
+
+	e.loginOK("alice@example.com", "emails")
+	e.loginFails("bob@gmail.com", "emails")
+	e.loginOK("bob@gmail.com", "groups")
+	e.loginFails("alice@example.com", "groups")
+	e.loginOK("alice@example.com", "aliases")
+
+	e.fails(logical.UpdateOperation, "role/invalid", map[string]interface{}{"bound_group_patterns": "/(/", "policies": "dev"})
+
+	// checking the groups the role refers to never finds a group by pattern
+	e.writeConfig(map[string]interface{}{pathConfigGroupLookupProp: groupLookupCheck})
+	resp, err := e.login("bob@gmail.com", "groups")
+	if err != nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "group_lookup=check") {
+		t.Fatalf("expected a rejection naming the group lookup; got %v %v", resp, err)
+	}
+
+	e.fails(logical.UpdateOperation, "role/groups", map[string]interface{}{"bound_group_patterns": "/plat.*@example\\.com/", "policies": "dev"})
+	e.writeRole("mixed", map[string]interface{}{"bound_emails": "alice@example.com", "bound_group_patterns": "plat*@example.com", "policies": "dev"})
+	e.loginOK("alice@example.com", "mixed")
+}
+
+func TestLogin_EmailAddresses(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("alias", map[string]interface{}{"bound_emails": "A.Example@Example.com", "policies": "dev"})
+	e.writeRole("case", map[string]interface{}{"bound_emails": "BOB@gmail.com", "policies": "dev"})
+	e.writeRole("deny", map[string]interface{}{"bound_groups": "eng@example.com", "denied_emails": "a.example@example.com", "policies": "dev"})
+
+	if emails := e.ok(logical.ReadOperation, "role/alias", nil).Data["bound_emails"]; !strutil.EquivalentSlices(emails.([]string), []string{"a.example@example.com"}) {
+		t.Fatalf("unexpected bound emails %v", emails)
+	}
+
+	// alice's Directory account has the alias the role is bound to
+	e.loginOK("alice@example.com", "alias")
+	e.loginFails("alice@example.com", "deny")
+
+	// the Directory refuses to serve consumer accounts, which are matched by the address they signed in with
+	e.loginOK("bob@gmail.com", "case")
+
+	// ... but whose aliases cannot be checked against denied addresses
+	e.writeRole("case", map[string]interface{}{"bound_emails": "BOB@gmail.com", "denied_emails": "robert@gmail.com", "policies": "dev"})
+	e.loginFails("bob@gmail.com", "case")
+
+	// without a service account, only the address the user signed in with is known
+	e.writeConfig(map[string]interface{}{pathConfigServiceAccountKeyProp: nil, pathConfigFetchGroupsProp: false})
+	e.loginFails("alice@example.com", "alias")
+}
+
+func TestLogin_EmailAddressesDirectoryErrors(t *testing.T) {
+	fixture := `
+users:
+  - id: "1001"
+    email: alice@example.com
+    hd: example.com
+    aliases: [a.example@example.com]
+  - id: "1004"
+    email: dave@example.com
+`
+
+	e := newTestEnvWithFixture(t, fixture)
+	e.writeRole("alice", map[string]interface{}{"bound_emails": "alice@example.com", "policies": "dev"})
+	e.writeRole("dave", map[string]interface{}{"bound_emails": "dave@example.com", "policies": "dev"})
+	e.loginOK("alice@example.com", "alice")
+
+	// the Directory refusing an account of the customer's domain is an error, unlike accounts of other customers
+	e.loginFails("dave@example.com", "dave")
+
+	// the service account is not delegated the scope to read users; the login fails rather than skip the aliases
+	e = newTestEnvWithFixture(t, fixture+`
+delegated_scopes:
+  - https://www.googleapis.com/auth/admin.directory.group.readonly
+`)
+	e.writeRole("alice", map[string]interface{}{"bound_emails": "alice@example.com", "policies": "dev"})
+	e.loginFails("alice@example.com", "alice")
+}
+
+func TestLogin_TokenParams(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("eng", map[string]interface{}{
+		"bound_groups":            "eng@example.com",
+		"token_policies":          "dev",
+		"token_period":            "30m",
+		"token_num_uses":          3,
+		"token_bound_cidrs":       "10.0.0.0/8",
+		"token_no_default_policy": true,
+	})
+
+	auth := e.loginOK("alice@example.com", "eng")
+	if auth.Period != 30*time.Minute || auth.NumUses != 3 || len(auth.BoundCIDRs) != 1 || auth.TokenType != logical.TokenTypeDefault {
+		t.Fatalf("token parameters were not applied: %#v", auth)
+	}
+
+	if renewed := e.renewOK(auth); renewed.Period != 30*time.Minute {
+		t.Fatalf("renewal dropped the period; got %s", renewed.Period)
+	}
+
+	e.writeRole("batch", map[string]interface{}{"bound_groups": "eng@example.com", "token_policies": "dev", "token_type": "batch"})
+	if auth := e.loginOK("alice@example.com", "batch"); auth.TokenType != logical.TokenTypeBatch {
+		t.Fatalf("expected a batch token; got %s", auth.TokenType)
+	}
+
+	e.fails(logical.UpdateOperation, "role/invalid", map[string]interface{}{"bound_groups": "eng@example.com", "token_policies": "dev", "token_type": "batch", "token_period": "1h"})
+}
+
+func TestLogin_TokenMetadata(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("eng", map[string]interface{}{
+		"bound_groups":          "eng@example.com,platform@example.com",
+		"policies":              "dev",
+		"display_name_template": "{{.name}} ({{.hd}})",
+		"metadata_templates": map[string]interface{}{
+			"user_id":  "{{.user_id}}",
+			"role":     "{{.role}}",
+			"groups":   "{{.groups}}",
+			"org_unit": "{{.org_unit}}",
+		},
+		"metadata_group_patterns": "eng*@example.com",
+	})
+
+	auth := e.loginOK("alice@example.com", "eng")
+	if auth.DisplayName != "Alice Example (example.com)" {
+		t.Fatalf("unexpected display name %q", auth.DisplayName)
+	}
+
+	expected := map[string]string{
+		"username": "alice@example.com",
+		"user_id":  "1001",
+		"role":     "eng",
+		"groups":   "eng@example.com",
+		"org_unit": "/Engineering",
+	}
+	for key, value := range expected {
+		if auth.Metadata[key] != value {
+			t.Fatalf("expected metadata %s=%q; got %v", key, value, auth.Metadata)
+		}
+	}
+
+	// the Directory refuses to read accounts of other customers; their Directory fields stay empty
+	auth = e.loginOK("bob@gmail.com", "eng")
+	if auth.DisplayName != "Bob ()" || auth.Metadata["org_unit"] != "" {
+		t.Fatalf("unexpected display name %q and metadata %v", auth.DisplayName, auth.Metadata)
+	}
+
+	for name, data := range map[string]map[string]interface{}{
+		"unknown field":  {"display_name_template": "{{.nickname}}"},
+		"invalid syntax": {"metadata_templates": map[string]interface{}{"name": "{{.name"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			data["bound_groups"] = "eng@example.com"
+			data["policies"] = "dev"
+			e.fails(logical.UpdateOperation, "role/invalid", data)
+		})
+	}
+}
+
+const testPolicyGroupsFixture = `
+users:
+  - id: "1001"
+    email: alice@example.com
+    hd: example.com
+groups:
+  - id: group-staff
+    email: staff@example.com
+    members: [{email: alice@example.com}]
+  - id: group-dev
+    email: vault-dev@example.com
+    aliases: [vault-developers@example.com]
+    members: [{email: alice@example.com}]
+  - id: group-admin
+    email: vault-admin@example.com
+    members: [{email: alice@example.com}]
+  - id: group-root
+    email: vault-root@example.com
+    members: [{email: alice@example.com}]
+`
+
+func TestLogin_GroupPolicyTemplates(t *testing.T) {
+	e := newTestEnvWithFixture(t, testPolicyGroupsFixture)
+	e.writeRole("teams", map[string]interface{}{
+		"bound_groups":           "staff@example.com",
+		"policies":               "base",
+		"group_policy_templates": map[string]interface{}{"vault-*@example.com": "$1"},
+	})
+
+	// the root policy is never derived
+	auth := e.loginOK("alice@example.com", "teams")
+	assertPolicies(t, auth, "base", "dev", "developers", "admin")
+	e.renewOK(auth)
+
+	e.writeRole("teams", map[string]interface{}{
+		"bound_groups":           "staff@example.com",
+		"policies":               "base",
+		"group_policy_templates": map[string]interface{}{`/vault-(?P<team>[a-z]+)@example\.com/`: "team-${team}"},
+		"group_policy_denylist":  "team-admin",
+	})
+	assertPolicies(t, e.loginOK("alice@example.com", "teams"), "base", "team-dev", "team-developers", "team-root")
+
+	e.writeRole("teams", map[string]interface{}{
+		"bound_groups":           "staff@example.com",
+		"policies":               "base",
+		"group_policy_templates": map[string]interface{}{"vault-*@example.com": "$1"},
+		"group_policy_allowlist": "dev*",
+	})
+	assertPolicies(t, e.loginOK("alice@example.com", "teams"), "base", "dev", "developers")
+
+	e.fails(logical.UpdateOperation, "role/invalid", map[string]interface{}{
+		"bound_groups":           "staff@example.com",
+		"policies":               "base",
+		"group_policy_templates": map[string]interface{}{"/vault-(@example.com/": "$1"},
+	})
+}
+
+func TestLogin_GroupPolicyTemplatesCheckMode(t *testing.T) {
+	e := newTestEnvWithFixture(t, testPolicyGroupsFixture)
+	e.writeConfig(map[string]interface{}{pathConfigGroupLookupProp: "check"})
+	e.writeRole("teams", map[string]interface{}{
+		"bound_groups":           "staff@example.com,vault-dev@example.com",
+		"policies":               "base",
+		"group_policy_templates": map[string]interface{}{"vault-*@example.com": "$1"},
+	})
+
+	// only the groups the role refers to are known, on every login
+	for i := 0; i < 5; i++ {
+		assertPolicies(t, e.loginOK("alice@example.com", "teams"), "base", "dev", "developers")
+	}
+}
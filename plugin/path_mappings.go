@@ -0,0 +1,189 @@
+package gaccauth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/policyutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathMappingsNameProp     = "name"
+	pathMappingsPoliciesProp = "policies"
+	pathGroupsPattern        = "groups/"
+	pathUsersPattern         = "users/"
+	groupMappingPrefix       = "group/"
+	userMappingPrefix        = "user/"
+)
+
+// policyMapping assigns policies to the members of a Google group, or to a Google user, whatever role they log in
+// with.
+type policyMapping struct {
+	Policies []string `json:"policies" structs:"policies" mapstructure:"policies"`
+}
+
+func pathGroups(b *googleAccountAuthBackend) []*framework.Path {
+	return pathMappings(b, pathGroupsPattern, groupMappingPrefix, "Google group (email address or ID)")
+}
+
+func pathUsers(b *googleAccountAuthBackend) []*framework.Path {
+	return pathMappings(b, pathUsersPattern, userMappingPrefix, "Google user (email address)")
+}
+
+// pathMappings builds the CRUD paths of the policy mappings stored under the given prefix.
+func pathMappings(b *googleAccountAuthBackend, pattern string, prefix string, subject string) []*framework.Path {
+	mapping := &framework.Path{
+		Pattern:         pattern + "(?P<name>.+)",
+		HelpSynopsis:    fmt.Sprintf("Manage the policies assigned to a %s.", subject),
+		HelpDescription: fmt.Sprintf("Policies assigned to a %s are added to the tokens it logs in with, whatever the role.", subject),
+		Fields: Schema{
+			pathMappingsNameProp: {
+				Type:        framework.TypeString,
+				Description: fmt.Sprintf("Name of the %s.", subject),
+			},
+			pathMappingsPoliciesProp: {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma separate list of policies assigned.",
+			},
+		},
+		Callbacks: ActionCallback{
+			logical.UpdateOperation: b.pathMappingWrite(prefix),
+			logical.ReadOperation:   b.pathMappingRead(prefix),
+			logical.DeleteOperation: b.pathMappingDelete(prefix),
+		},
+	}
+
+	mappings := &framework.Path{
+		Pattern:         strings.TrimSuffix(pattern, "/") + "/?",
+		HelpSynopsis:    fmt.Sprintf("Lists the policy mappings of every %s.", subject),
+		HelpDescription: fmt.Sprintf("Lists the names of the %s policy mappings.", subject),
+		Callbacks: ActionCallback{
+			logical.ListOperation: b.pathMappingList(prefix),
+		},
+	}
+
+	return []*framework.Path{mapping, mappings}
+}
+
+func mappingName(data *framework.FieldData) string {
+	return lowerEmails([]string{strings.TrimSpace(data.Get(pathMappingsNameProp).(string))})[0]
+}
+
+func (b *googleAccountAuthBackend) pathMappingWrite(prefix string) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		name := mappingName(data)
+		if name == "" {
+			return logical.ErrorResponse("name is required"), nil
+		}
+
+		mapping := &policyMapping{
+			Policies: policyutil.ParsePolicies(data.Get(pathMappingsPoliciesProp)),
+		}
+
+		if err := validatePolicies(mapping.Policies); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+
+		entry, err := logical.StorageEntryJSON(prefix+name, mapping)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, req.Storage.Put(ctx, entry)
+	}
+}
+
+func (b *googleAccountAuthBackend) pathMappingRead(prefix string) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		mapping, err := getPolicyMapping(ctx, req.Storage, prefix, mappingName(data))
+		if err != nil {
+			return nil, err
+		}
+
+		if mapping == nil {
+			return nil, nil
+		}
+
+		response := &logical.Response{
+			Data: GenericMap{
+				pathMappingsNameProp:     mappingName(data),
+				pathMappingsPoliciesProp: mapping.Policies,
+			},
+		}
+
+		return response, nil
+	}
+}
+
+func (b *googleAccountAuthBackend) pathMappingDelete(prefix string) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		return nil, req.Storage.Delete(ctx, prefix+mappingName(data))
+	}
+}
+
+func (b *googleAccountAuthBackend) pathMappingList(prefix string) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		names, err := req.Storage.List(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		return logical.ListResponse(names), nil
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+
+func getPolicyMapping(ctx context.Context, s logical.Storage, prefix string, name string) (*policyMapping, error) {
+	entry, err := s.Get(ctx, prefix+name)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	mapping := &policyMapping{}
+	if err := entry.DecodeJSON(mapping); err != nil {
+		return nil, err
+	}
+
+	return mapping, nil
+}
+
+// mappedPolicies adds up the policies mapped to any of the addresses of the user and to any of their groups, the
+// latter being looked up by email address, alias and ID.
+func mappedPolicies(ctx context.Context, s logical.Storage, addresses []string, groups []*googleGroup) ([]string, error) {
+	policies := []string{}
+
+	lookup := func(prefix string, names []string) error {
+		for _, name := range names {
+			mapping, err := getPolicyMapping(ctx, s, prefix, name)
+			if err != nil {
+				return err
+			}
+
+			if mapping != nil {
+				policies = append(policies, mapping.Policies...)
+			}
+		}
+
+		return nil
+	}
+
+	if err := lookup(userMappingPrefix, addresses); err != nil {
+		return nil, err
+	}
+
+	for _, group := range groups {
+		if err := lookup(groupMappingPrefix, append(lowerAll(append([]string{group.Email}, group.Aliases...)), group.ID)); err != nil {
+			return nil, err
+		}
+	}
+
+	return policyutil.SanitizePolicies(policies, false), nil
+}
@@ -0,0 +1,88 @@
+package gaccauth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestMappings_CRUD(t *testing.T) {
+	e := newTestEnv(t)
+	e.ok(logical.UpdateOperation, "groups/Eng@Example.com", map[string]interface{}{"policies": "dev,shared"})
+	e.ok(logical.UpdateOperation, "users/alice@example.com", map[string]interface{}{"policies": "alice"})
+
+	resp := e.ok(logical.ReadOperation, "groups/eng@example.com", nil)
+	if policies := resp.Data["policies"].([]string); len(policies) != 2 {
+		t.Fatalf("unexpected policies %v", policies)
+	}
+
+	resp = e.ok(logical.ListOperation, "groups/", nil)
+	if keys := resp.Data["keys"].([]string); len(keys) != 1 || keys[0] != "eng@example.com" {
+		t.Fatalf("unexpected group mappings %v", keys)
+	}
+
+	e.fails(logical.UpdateOperation, "users/alice@example.com", map[string]interface{}{"policies": "dev,root"})
+
+	e.ok(logical.DeleteOperation, "users/alice@example.com", nil)
+	if resp := e.ok(logical.ReadOperation, "users/alice@example.com", nil); resp != nil {
+		t.Fatalf("mapping was not deleted: %v", resp.Data)
+	}
+}
+
+func TestMappings_Login(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("append", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev"})
+	e.writeRole("replace", map[string]interface{}{"bound_groups": "eng@example.com", "mapped_policies": "replace"})
+
+	// groups are looked up by email address, alias and ID
+	e.ok(logical.UpdateOperation, "groups/engineering@example.com", map[string]interface{}{"policies": "eng"})
+	e.ok(logical.UpdateOperation, "groups/group-ops", map[string]interface{}{"policies": "ops"})
+	e.ok(logical.UpdateOperation, "users/alice@example.com", map[string]interface{}{"policies": "alice"})
+
+	assertPolicies(t, e.loginOK("alice@example.com", "append"), "dev", "eng", "ops", "alice")
+
+	auth := e.loginOK("alice@example.com", "replace")
+	assertPolicies(t, auth, "eng", "ops", "alice")
+
+	// renewals recompute the mapped policies
+	e.renewOK(auth)
+	e.ok(logical.DeleteOperation, "users/alice@example.com", nil)
+	e.renewFails(auth)
+
+	// carol is in the role's group through ops@ only; once its mapping is gone, replace roles have nothing to grant
+	e.writeRole("replace", map[string]interface{}{"bound_groups": "ops@example.com", "mapped_policies": "replace"})
+	assertPolicies(t, e.loginOK("carol@example.com", "replace"), "ops")
+
+	e.ok(logical.DeleteOperation, "groups/group-ops", nil)
+	resp, err := e.login("carol@example.com", "replace")
+	if err != nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "no policies are mapped") {
+		t.Fatalf("expected a rejection for the lack of mapped policies; got %v %v", resp, err)
+	}
+
+	// roles adding mapped policies to their own are not concerned
+	e.writeRole("append", map[string]interface{}{"bound_groups": "ops@example.com", "policies": "dev"})
+	assertPolicies(t, e.loginOK("carol@example.com", "append"), "dev")
+}
+
+func TestMappings_CheckMode(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeConfig(map[string]interface{}{pathConfigGroupLookupProp: "check"})
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com,ops@example.com", "policies": "dev"})
+
+	e.ok(logical.UpdateOperation, "groups/eng@example.com", map[string]interface{}{"policies": "eng"})
+	e.ok(logical.UpdateOperation, "groups/ops@example.com", map[string]interface{}{"policies": "ops"})
+	e.ok(logical.UpdateOperation, "groups/platform@example.com", map[string]interface{}{"policies": "platform"})
+
+	e.writeRole("all", map[string]interface{}{"bound_groups": "eng@example.com,ops@example.com", "group_match_mode": "all", "policies": "dev"})
+
+	// the platform group is not one the role refers to; the eng role stops at its first group the user is a member
+	// of, while the all role checks both, on every login
+	for i := 0; i < 5; i++ {
+		auth := e.loginOK("alice@example.com", "eng")
+		assertPolicies(t, auth, "dev", "eng")
+		e.renewOK(auth)
+
+		assertPolicies(t, e.loginOK("alice@example.com", "all"), "dev", "eng", "ops")
+	}
+}
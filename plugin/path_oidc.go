@@ -0,0 +1,176 @@
+package gaccauth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// The paths below follow the request and response shape of Vault's JWT/OIDC auth method, which is what the Vault web
+// UI expects when completing an OIDC login.
+const (
+	pathOIDCAuthURLPattern     = "oidc/auth_url"
+	pathOIDCCallbackPattern    = "oidc/callback"
+	pathOIDCRoleNameProp       = "role"
+	pathOIDCRedirectURIProp    = "redirect_uri"
+	pathOIDCClientNonceProp    = "client_nonce"
+	pathOIDCStateProp          = "state"
+	pathOIDCGoogleAuthCodeProp = "code"
+)
+
+func pathOIDC(b *googleAccountAuthBackend) []*framework.Path {
+	authURL := &framework.Path{
+		Pattern:      pathOIDCAuthURLPattern,
+		HelpSynopsis: "Request an authorization URL to start a Google OAuth login flow.",
+		Fields: Schema{
+			pathOIDCRoleNameProp: {
+				Type:        framework.TypeString,
+				Description: "Name of the role against which the login is being attempted. Defaults to the configured default role",
+			},
+			pathOIDCRedirectURIProp: {
+				Type:        framework.TypeString,
+				Description: "Google OAuth redirect URL",
+			},
+			pathOIDCClientNonceProp: {
+				Type:        framework.TypeString,
+				Description: "Optional client-provided nonce that must match the one sent to the callback",
+			},
+		},
+		Callbacks: ActionCallback{
+			logical.UpdateOperation: b.pathOIDCAuthURL,
+		},
+	}
+
+	callback := &framework.Path{
+		Pattern:      pathOIDCCallbackPattern,
+		HelpSynopsis: "Callback endpoint to complete a Google OAuth login.",
+		Fields: Schema{
+			pathOIDCStateProp: {
+				Type:        framework.TypeString,
+				Description: "State returned by Google along with the authentication code",
+			},
+			pathOIDCGoogleAuthCodeProp: {
+				Type:        framework.TypeString,
+				Description: "Google authentication code",
+			},
+			pathOIDCClientNonceProp: {
+				Type:        framework.TypeString,
+				Description: "Client-provided nonce sent to the auth_url path",
+			},
+		},
+		Callbacks: ActionCallback{
+			logical.ReadOperation: b.pathOIDCCallback,
+		},
+	}
+
+	return []*framework.Path{authURL, callback}
+}
+
+func (b *googleAccountAuthBackend) pathOIDCAuthURL(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	googleOAuth, err := b.getGoogleOAuthConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if googleOAuth == nil {
+		return logical.ErrorResponse("missing Google OAuth config"), nil
+	}
+
+	roleName := data.Get(pathOIDCRoleNameProp).(string)
+	if roleName == "" {
+		roleName = googleOAuth.DefaultRole
+	}
+
+	if roleName == "" {
+		return logical.ErrorResponse("missing role"), nil
+	}
+
+	role, err := b.getDecodedRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role '%s' not found", roleName)), nil
+	}
+
+	redirectURI := data.Get(pathOIDCRedirectURIProp).(string)
+	if redirectURI == "" {
+		return logical.ErrorResponse("missing redirect_uri"), nil
+	}
+
+	if !isValidUrl(redirectURI) {
+		return logical.ErrorResponse(fmt.Sprintf("property '%s' must be a valid URL; got '%s'", pathOIDCRedirectURIProp, redirectURI)), nil
+	}
+
+	state := &oauthState{
+		RoleName:    roleName,
+		RedirectURI: redirectURI,
+		ClientNonce: data.Get(pathOIDCClientNonceProp).(string),
+	}
+
+	stateID, err := b.createOAuthState(ctx, req.Storage, state)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &logical.Response{
+		Data: GenericMap{
+			"auth_url": googleOAuth.authCodeURL(stateID, state, role.authCodeOptions()...),
+		},
+	}
+
+	return response, nil
+}
+
+func (b *googleAccountAuthBackend) pathOIDCCallback(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	googleOAuth, err := b.getGoogleOAuthConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if googleOAuth == nil {
+		return logical.ErrorResponse("missing Google OAuth config"), nil
+	}
+
+	stateID := data.Get(pathOIDCStateProp).(string)
+	if stateID == "" {
+		return logical.ErrorResponse("missing state"), nil
+	}
+
+	state, err := b.consumeOAuthState(ctx, req.Storage, stateID)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if state == nil {
+		return logical.ErrorResponse("invalid state"), nil
+	}
+
+	if state.ClientNonce != "" && state.ClientNonce != data.Get(pathOIDCClientNonceProp).(string) {
+		return logical.ErrorResponse("invalid client_nonce"), nil
+	}
+
+	role, err := b.getDecodedRole(ctx, req.Storage, state.RoleName)
+	if err != nil {
+		return nil, err
+	}
+
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role '%s' not found", state.RoleName)), nil
+	}
+
+	code := data.Get(pathOIDCGoogleAuthCodeProp).(string)
+	if code == "" {
+		return logical.ErrorResponse("missing code"), nil
+	}
+
+	token, err := googleOAuth.exchange(ctx, code, state)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.loginWithToken(ctx, req, googleOAuth, state.RoleName, role, token)
+}
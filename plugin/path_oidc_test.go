@@ -0,0 +1,64 @@
+package gaccauth
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const testUIRedirectURI = "https://vault.example.com/ui/vault/auth/google/oidc/callback"
+
+func TestOIDC_AuthURL(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev"})
+
+	// the Vault web UI does not name a role unless the user types one
+	e.fails(logical.UpdateOperation, pathOIDCAuthURLPattern, map[string]interface{}{pathOIDCRedirectURIProp: testUIRedirectURI})
+
+	e.writeConfig(map[string]interface{}{pathConfigDefaultRoleProp: "eng"})
+	resp := e.ok(logical.UpdateOperation, pathOIDCAuthURLPattern, map[string]interface{}{pathOIDCRedirectURIProp: testUIRedirectURI})
+	if resp.Data["auth_url"] == "" {
+		t.Fatal("expected an auth_url")
+	}
+
+	e.fails(logical.UpdateOperation, pathOIDCAuthURLPattern, map[string]interface{}{pathOIDCRoleNameProp: "eng"})
+	e.fails(logical.UpdateOperation, pathOIDCAuthURLPattern, map[string]interface{}{pathOIDCRoleNameProp: "eng", pathOIDCRedirectURIProp: "not a url"})
+	e.fails(logical.UpdateOperation, pathOIDCAuthURLPattern, map[string]interface{}{pathOIDCRoleNameProp: "unknown", pathOIDCRedirectURIProp: testUIRedirectURI})
+}
+
+func TestOIDC_Callback(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeConfig(map[string]interface{}{pathConfigDefaultRoleProp: "eng"})
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev"})
+
+	start := func(nonce string) map[string]interface{} {
+		resp := e.ok(logical.UpdateOperation, pathOIDCAuthURLPattern, map[string]interface{}{
+			pathOIDCRedirectURIProp: testUIRedirectURI,
+			pathOIDCClientNonceProp: nonce,
+		})
+
+		query := e.approve(resp.Data["auth_url"].(string), "alice@example.com")
+		return map[string]interface{}{
+			pathOIDCStateProp:          query.Get("state"),
+			pathOIDCGoogleAuthCodeProp: query.Get("code"),
+			pathOIDCClientNonceProp:    nonce,
+		}
+	}
+
+	callback := start("nonce")
+	resp := e.ok(logical.ReadOperation, pathOIDCCallbackPattern, callback)
+	if resp.Auth == nil {
+		t.Fatal("expected the callback to log the user in")
+	}
+	assertPolicies(t, resp.Auth, "dev")
+
+	// states are single-use
+	e.fails(logical.ReadOperation, pathOIDCCallbackPattern, callback)
+
+	callback = start("nonce")
+	callback[pathOIDCClientNonceProp] = "another nonce"
+	e.fails(logical.ReadOperation, pathOIDCCallbackPattern, callback)
+
+	e.fails(logical.ReadOperation, pathOIDCCallbackPattern, map[string]interface{}{pathOIDCStateProp: "unknown", pathOIDCGoogleAuthCodeProp: "code"})
+	e.fails(logical.ReadOperation, pathOIDCCallbackPattern, map[string]interface{}{pathOIDCGoogleAuthCodeProp: "code"})
+}
@@ -7,18 +7,49 @@ import (
 	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
-	"github.com/hashicorp/vault/sdk/helper/policyutil"
+	"github.com/hashicorp/vault/sdk/helper/tokenutil"
 	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
 )
 
 const (
-	pathRolesNameProp        = "name"
-	pathRolesPoliciesProp    = "policies"
-	pathRolesBoundEmailsProp = "bound_emails"
-	pathRolesBoundGroupsProp = "bound_groups"
-	pathRolesMaxTTLProp      = "max_ttl"
-	pathRolesTTLProp         = "ttl"
-	errEmptyRoleName         = "role name is required"
+	pathRolesNameProp                  = "name"
+	pathRolesPoliciesProp              = "policies"
+	pathRolesBoundEmailsProp           = "bound_emails"
+	pathRolesBoundGroupsProp           = "bound_groups"
+	pathRolesGroupMatchModeProp        = "group_match_mode"
+	pathRolesRequiredGroupsProp        = "required_groups"
+	pathRolesDeniedEmailsProp          = "denied_emails"
+	pathRolesBoundEmailPatternsProp    = "bound_email_patterns"
+	pathRolesBoundGroupPatternsProp    = "bound_group_patterns"
+	pathRolesDeniedGroupsProp          = "denied_groups"
+	pathRolesBoundClaimsProp           = "bound_claims"
+	pathRolesBoundGroupRolesProp       = "bound_group_roles"
+	pathRolesBoundDomainsProp          = "bound_hosted_domains"
+	pathRolesRejectConsumerProp        = "reject_consumer_accounts"
+	pathRolesTransitiveGroupsProp      = "transitive_groups"
+	pathRolesMappedPoliciesProp        = "mapped_policies"
+	pathRolesGroupPolicyTemplatesProp  = "group_policy_templates"
+	pathRolesGroupPolicyAllowlistProp  = "group_policy_allowlist"
+	pathRolesGroupPolicyDenylistProp   = "group_policy_denylist"
+	pathRolesDisplayNameTemplateProp   = "display_name_template"
+	pathRolesMetadataTemplatesProp     = "metadata_templates"
+	pathRolesMetadataGroupPatternsProp = "metadata_group_patterns"
+	pathRolesMaxTTLProp                = "max_ttl"
+	pathRolesTTLProp                   = "ttl"
+	errEmptyRoleName                   = "role name is required"
+)
+
+// Ways the bound groups of a role are matched against the groups of the user.
+const (
+	groupMatchAny = "any"
+	groupMatchAll = "all"
+)
+
+// Ways the policies mapped to users and groups are combined with the policies of a role.
+const (
+	mappedPoliciesAppend  = "append"
+	mappedPoliciesReplace = "replace"
 )
 
 const pathRolesHelpSyn = `
@@ -28,11 +59,33 @@ bound policies to determine which resources the authorization token for the inst
 `
 
 type googleAuthRole struct {
-	Policies    []string      `json:"policies" structs:"policies" mapstructure:"policies"`
-	BoundGroups []string      `json:"bound_groups" structs:"bound_groups" mapstructure:"bound_groups"`
-	BoundEmails []string      `json:"bound_emails" structs:"bound_emails" mapstructure:"bound_emails"`
-	TTL         time.Duration `json:"ttl" structs:"ttl" mapstructure:"ttl"`
-	MaxTTL      time.Duration `json:"max_ttl" structs:"max_ttl" mapstructure:"max_ttl"`
+	tokenutil.TokenParams
+
+	BoundGroups            []string            `json:"bound_groups" structs:"bound_groups" mapstructure:"bound_groups"`
+	GroupMatchMode         string              `json:"group_match_mode" structs:"group_match_mode" mapstructure:"group_match_mode"`
+	RequiredGroups         []string            `json:"required_groups" structs:"required_groups" mapstructure:"required_groups"`
+	DeniedEmails           []string            `json:"denied_emails" structs:"denied_emails" mapstructure:"denied_emails"`
+	BoundEmailPatterns     []string            `json:"bound_email_patterns" structs:"bound_email_patterns" mapstructure:"bound_email_patterns"`
+	BoundGroupPatterns     []string            `json:"bound_group_patterns" structs:"bound_group_patterns" mapstructure:"bound_group_patterns"`
+	DeniedGroups           []string            `json:"denied_groups" structs:"denied_groups" mapstructure:"denied_groups"`
+	BoundEmails            []string            `json:"bound_emails" structs:"bound_emails" mapstructure:"bound_emails"`
+	BoundClaims            map[string]string   `json:"bound_claims" structs:"bound_claims" mapstructure:"bound_claims"`
+	BoundGroupRoles        map[string][]string `json:"bound_group_roles" structs:"bound_group_roles" mapstructure:"bound_group_roles"`
+	BoundHostedDomains     []string            `json:"bound_hosted_domains" structs:"bound_hosted_domains" mapstructure:"bound_hosted_domains"`
+	RejectConsumerAccounts bool                `json:"reject_consumer_accounts" structs:"reject_consumer_accounts" mapstructure:"reject_consumer_accounts"`
+	TransitiveGroups       bool                `json:"transitive_groups" structs:"transitive_groups" mapstructure:"transitive_groups"`
+	MappedPolicies         string              `json:"mapped_policies" structs:"mapped_policies" mapstructure:"mapped_policies"`
+	GroupPolicyTemplates   map[string]string   `json:"group_policy_templates" structs:"group_policy_templates" mapstructure:"group_policy_templates"`
+	GroupPolicyAllowlist   []string            `json:"group_policy_allowlist" structs:"group_policy_allowlist" mapstructure:"group_policy_allowlist"`
+	GroupPolicyDenylist    []string            `json:"group_policy_denylist" structs:"group_policy_denylist" mapstructure:"group_policy_denylist"`
+	DisplayNameTemplate    string              `json:"display_name_template" structs:"display_name_template" mapstructure:"display_name_template"`
+	MetadataTemplates      map[string]string   `json:"metadata_templates" structs:"metadata_templates" mapstructure:"metadata_templates"`
+	MetadataGroupPatterns  []string            `json:"metadata_group_patterns" structs:"metadata_group_patterns" mapstructure:"metadata_group_patterns"`
+
+	// deprecated in favor of their token_* counterparts of TokenParams
+	Policies []string      `json:"policies" structs:"policies" mapstructure:"policies"`
+	TTL      time.Duration `json:"ttl" structs:"ttl" mapstructure:"ttl"`
+	MaxTTL   time.Duration `json:"max_ttl" structs:"max_ttl" mapstructure:"max_ttl"`
 }
 
 func pathRoles(b *googleAccountAuthBackend) []*framework.Path {
@@ -48,23 +101,100 @@ func pathRoles(b *googleAccountAuthBackend) []*framework.Path {
 			},
 			pathRolesPoliciesProp: {
 				Type:        framework.TypeCommaStringSlice,
-				Description: "Policies to be set on tokens issued using this role.",
+				Description: tokenutil.DeprecationText("token_policies"),
+				Deprecated:  true,
 			},
 			pathRolesBoundGroupsProp: {
 				Type:        framework.TypeCommaStringSlice,
-				Description: "Comma separate list of groups, at least one of which the user must be in to grant this role.",
+				Description: "Comma separate list of groups (email address, alias or ID), at least one of which the user must be in to grant this role.",
+			},
+			pathRolesGroupMatchModeProp: {
+				Type:        framework.TypeString,
+				Default:     groupMatchAny,
+				Description: "Whether the user must be in any ('any') or all ('all') of the bound groups to grant this role.",
+			},
+			pathRolesRequiredGroupsProp: {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma separate list of groups the user must all be in to grant this role, on top of the other bindings.",
+			},
+			pathRolesBoundEmailPatternsProp: {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma separate list of globs, or regular expressions enclosed in slashes, one of which the username must match to grant this role.",
+			},
+			pathRolesBoundGroupPatternsProp: {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma separate list of globs, or regular expressions enclosed in slashes, one of which a group of the user must match to grant this role.",
+			},
+			pathRolesDeniedEmailsProp: {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma separate list of usernames denied this role, even if they match its other bindings.",
+			},
+			pathRolesDeniedGroupsProp: {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma separate list of groups whose members are denied this role, even if they match its other bindings.",
+			},
+			pathRolesBoundGroupRolesProp: {
+				Type:        framework.TypeKVPairs,
+				Description: "Groups, and the membership roles (OWNER, MANAGER or MEMBER, separated by '|'), one of which the user must hold in one of the groups to grant this role.",
 			},
 			pathRolesBoundEmailsProp: {
 				Type:        framework.TypeCommaStringSlice,
 				Description: "Comma separate list of usernames, which the user must be in to grant this role.",
 			},
+			pathRolesBoundClaimsProp: {
+				Type:        framework.TypeKVPairs,
+				Description: "Claims, and their values, the user's Google identity must have to grant this role.",
+			},
+			pathRolesBoundDomainsProp: {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma separate list of Google Workspace domains, one of which the user's account must belong to to grant this role.",
+			},
+			pathRolesRejectConsumerProp: {
+				Type:        framework.TypeBool,
+				Description: "Whether consumer Google accounts (e.g. Gmail), which belong to no Google Workspace domain, are rejected.",
+			},
+			pathRolesTransitiveGroupsProp: {
+				Type:        framework.TypeBool,
+				Description: "Whether the groups the user is an indirect member of (through nested groups) count towards bound_groups.",
+			},
+			pathRolesMappedPoliciesProp: {
+				Type:        framework.TypeString,
+				Default:     mappedPoliciesAppend,
+				Description: "Whether the policies mapped to the user and their groups are added to the policies of this role ('append') or used instead of them ('replace').",
+			},
+			pathRolesGroupPolicyTemplatesProp: {
+				Type:        framework.TypeKVPairs,
+				Description: "Group patterns (globs, or regular expressions enclosed in slashes), and the templates of the policy names derived from the groups of the user matching them, e.g. 'vault-*@example.com=$1'.",
+			},
+			pathRolesGroupPolicyAllowlistProp: {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma separate list of globs, or regular expressions enclosed in slashes, one of which policies derived from groups must match.",
+			},
+			pathRolesGroupPolicyDenylistProp: {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma separate list of globs, or regular expressions enclosed in slashes, that policies derived from groups must not match.",
+			},
+			pathRolesDisplayNameTemplateProp: {
+				Type:        framework.TypeString,
+				Description: "Template of the display name of issued tokens, e.g. '{{.name}} ({{.email}})'. Defaults to the email address.",
+			},
+			pathRolesMetadataTemplatesProp: {
+				Type:        framework.TypeKVPairs,
+				Description: "Metadata keys, and the templates of their values, set on issued tokens, e.g. 'domain={{.hd}}'. Templates can refer to " + strings.Join(metadataFields, ", ") + ".",
+			},
+			pathRolesMetadataGroupPatternsProp: {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma separate list of globs, or regular expressions enclosed in slashes, restricting the groups listed by the 'groups' template field.",
+			},
 			pathRolesTTLProp: {
 				Type:        framework.TypeDurationSecond,
-				Description: "Duration in seconds after which the issued token should expire.",
+				Description: tokenutil.DeprecationText("token_ttl"),
+				Deprecated:  true,
 			},
 			pathRolesMaxTTLProp: {
 				Type:        framework.TypeDurationSecond,
-				Description: "The maximum allowed lifetime of tokens issued using this role.",
+				Description: tokenutil.DeprecationText("token_max_ttl"),
+				Deprecated:  true,
 			},
 		},
 		Callbacks: ActionCallback{
@@ -75,6 +205,8 @@ func pathRoles(b *googleAccountAuthBackend) []*framework.Path {
 		},
 	}
 
+	tokenutil.AddTokenFields(role.Fields)
+
 	roles := &framework.Path{
 		Pattern:         "role/?",
 		HelpSynopsis:    "Lists all the roles that are registered with Vault.",
@@ -123,7 +255,16 @@ func (b *googleAccountAuthBackend) pathRoleUpsert(ctx context.Context, req *logi
 		r = &googleAuthRole{}
 	}
 
-	if err := r.parseAndValidateInput(b.System(), req.Operation, data); err != nil {
+	if err := r.parseAndValidateInput(b.System(), req, data); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	googleOAuth, err := b.getGoogleOAuthConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.validateWithConfig(googleOAuth); err != nil {
 		return logical.ErrorResponse(err.Error()), nil
 	}
 
@@ -165,15 +306,44 @@ func (b *googleAccountAuthBackend) pathRoleRead(ctx context.Context, req *logica
 
 	response := &logical.Response{
 		Data: GenericMap{
-			pathRolesNameProp:        name,
-			pathRolesPoliciesProp:    role.Policies,
-			pathRolesBoundGroupsProp: role.BoundGroups,
-			pathRolesBoundEmailsProp: role.BoundEmails,
-			pathRolesTTLProp:         fmt.Sprint(role.TTL / time.Second),
-			pathRolesMaxTTLProp:      fmt.Sprint(role.MaxTTL / time.Second),
+			pathRolesNameProp:                  name,
+			pathRolesBoundGroupsProp:           role.BoundGroups,
+			pathRolesGroupMatchModeProp:        role.groupMatchMode(),
+			pathRolesRequiredGroupsProp:        role.RequiredGroups,
+			pathRolesDeniedEmailsProp:          role.DeniedEmails,
+			pathRolesBoundEmailPatternsProp:    role.BoundEmailPatterns,
+			pathRolesBoundGroupPatternsProp:    role.BoundGroupPatterns,
+			pathRolesDeniedGroupsProp:          role.DeniedGroups,
+			pathRolesBoundEmailsProp:           role.BoundEmails,
+			pathRolesBoundGroupRolesProp:       role.BoundGroupRoles,
+			pathRolesBoundClaimsProp:           role.BoundClaims,
+			pathRolesBoundDomainsProp:          role.BoundHostedDomains,
+			pathRolesRejectConsumerProp:        role.RejectConsumerAccounts,
+			pathRolesTransitiveGroupsProp:      role.TransitiveGroups,
+			pathRolesMappedPoliciesProp:        role.mappedPolicies(),
+			pathRolesGroupPolicyTemplatesProp:  role.GroupPolicyTemplates,
+			pathRolesGroupPolicyAllowlistProp:  role.GroupPolicyAllowlist,
+			pathRolesGroupPolicyDenylistProp:   role.GroupPolicyDenylist,
+			pathRolesDisplayNameTemplateProp:   role.DisplayNameTemplate,
+			pathRolesMetadataTemplatesProp:     role.MetadataTemplates,
+			pathRolesMetadataGroupPatternsProp: role.MetadataGroupPatterns,
 		},
 	}
 
+	role.PopulateTokenData(response.Data)
+
+	if len(role.Policies) > 0 {
+		response.Data[pathRolesPoliciesProp] = response.Data["token_policies"]
+	}
+
+	if role.TTL > 0 {
+		response.Data[pathRolesTTLProp] = int64(role.TTL.Seconds())
+	}
+
+	if role.MaxTTL > 0 {
+		response.Data[pathRolesMaxTTLProp] = int64(role.MaxTTL.Seconds())
+	}
+
 	return response, nil
 }
 
@@ -194,84 +364,391 @@ func (b *googleAccountAuthBackend) getDecodedRole(ctx context.Context, s logical
 		return nil, err
 	}
 
+	// roles written before token parameters existed only have the deprecated fields
+	if role.TokenTTL == 0 && role.TTL > 0 {
+		role.TokenTTL = role.TTL
+	}
+
+	if role.TokenMaxTTL == 0 && role.MaxTTL > 0 {
+		role.TokenMaxTTL = role.MaxTTL
+	}
+
+	if len(role.TokenPolicies) == 0 && len(role.Policies) > 0 {
+		role.TokenPolicies = role.Policies
+	}
+
 	return role, nil
 }
 
 ///////////////////////////////////////////////////////////////////////////////
 
-func (r *googleAuthRole) parseAndValidateInput(sys logical.SystemView, op logical.Operation, data *framework.FieldData) error {
+// groupPolicies derives policy names from the groups of the user, through the role's group policy templates. Policies
+// not allowed by the role, and the root policy, are never derived.
+func (r *googleAuthRole) groupPolicies(groups []*googleGroup) []string {
+	policies := []string{}
+
+	for pattern, template := range r.GroupPolicyTemplates {
+		re, err := compilePattern(pattern)
+		if err != nil {
+			continue
+		}
+
+		for _, address := range groupAddresses(groups) {
+			match := re.FindStringSubmatchIndex(address)
+			if match == nil {
+				continue
+			}
+
+			policy := strings.ToLower(strings.TrimSpace(string(re.ExpandString(nil, template, address, match))))
+			if policy == "" || validatePolicies([]string{policy}) != nil {
+				continue
+			}
+
+			if len(r.GroupPolicyAllowlist) > 0 && !matchesAnyPattern([]string{policy}, r.GroupPolicyAllowlist) {
+				continue
+			}
+
+			if matchesAnyPattern([]string{policy}, r.GroupPolicyDenylist) {
+				continue
+			}
+
+			policies = append(policies, policy)
+		}
+	}
+
+	return policies
+}
+
+// validateWithConfig checks that the role can be used with the configuration of the mount, if any: groups must be
+// fetched for a role to deny some, and listed for a role bound by group patterns alone to admit anyone. Roles are
+// checked again on login, as the configuration may change meanwhile.
+func (r *googleAuthRole) validateWithConfig(c *googleOAuth) error {
+	if c == nil {
+		return nil
+	}
+
+	if len(r.DeniedGroups) > 0 && !c.FetchGroups {
+		return fmt.Errorf("'%s' cannot be enforced unless groups are fetched; set '%s' on the config", pathRolesDeniedGroupsProp, pathConfigFetchGroupsProp)
+	}
+
+	if len(r.BoundGroupPatterns) > 0 && r.bindingCount() == len(r.BoundGroupPatterns) && c.groupLookup() == groupLookupCheck {
+		return fmt.Errorf("'%s' never match with '%s=%s', which only checks the groups the role refers to; bind the role to '%s' instead", pathRolesBoundGroupPatternsProp, pathConfigGroupLookupProp, groupLookupCheck, pathRolesBoundGroupsProp)
+	}
+
+	return nil
+}
+
+// validatePolicies guards against granting the root policy.
+func validatePolicies(policies []string) error {
+	for _, policy := range policies {
+		if strings.ToLower(strings.TrimSpace(policy)) == "root" {
+			return fmt.Errorf("cannot use root policy")
+		}
+	}
+
+	return nil
+}
+
+// lowerEmails lower-cases the email addresses among the groups; group IDs are kept as they are.
+func lowerEmails(groups []string) []string {
+	lowered := make([]string, 0, len(groups))
+	for _, group := range groups {
+		if strings.Contains(group, "@") {
+			group = strings.ToLower(group)
+		}
+
+		lowered = append(lowered, group)
+	}
+
+	return lowered
+}
+
+// bindingCount returns the number of bindings of the role, one of which the user must match. Required groups are not
+// counted, as they narrow the other bindings down.
+func (r *googleAuthRole) bindingCount() int {
+	return len(r.BoundEmails) + len(r.BoundGroups) + len(r.BoundGroupRoles) + len(r.BoundEmailPatterns) + len(r.BoundGroupPatterns)
+}
+
+// mappedPolicies returns how the role combines mapped policies; roles written before it existed add them up.
+func (r *googleAuthRole) mappedPolicies() string {
+	return stringOrDefault(r.MappedPolicies, mappedPoliciesAppend)
+}
+
+// groupMatchMode returns the group match mode of the role; roles written before it existed match any group.
+func (r *googleAuthRole) groupMatchMode() string {
+	return stringOrDefault(r.GroupMatchMode, groupMatchAny)
+}
+
+func (r *googleAuthRole) parseAndValidateInput(sys logical.SystemView, req *logical.Request, data *framework.FieldData) error {
 	boundEmails := getFilteredStringSliceData(data, pathRolesBoundEmailsProp)
 	if boundEmails == nil {
 		r.BoundEmails = []string{}
 	} else {
-		r.BoundEmails = *boundEmails
+		r.BoundEmails = lowerAll(*boundEmails)
 	}
 
 	boundGroups := getFilteredStringSliceData(data, pathRolesBoundGroupsProp)
 	if boundGroups == nil {
 		r.BoundGroups = []string{}
 	} else {
-		r.BoundGroups = *boundGroups
+		r.BoundGroups = lowerEmails(*boundGroups)
+	}
+
+	r.GroupMatchMode = strings.ToLower(strings.TrimSpace(data.Get(pathRolesGroupMatchModeProp).(string)))
+	if r.GroupMatchMode != groupMatchAny && r.GroupMatchMode != groupMatchAll {
+		return fmt.Errorf("'%s' must be either '%s' or '%s'", pathRolesGroupMatchModeProp, groupMatchAny, groupMatchAll)
+	}
+
+	requiredGroups := getFilteredStringSliceData(data, pathRolesRequiredGroupsProp)
+	if requiredGroups == nil {
+		r.RequiredGroups = []string{}
+	} else {
+		r.RequiredGroups = lowerEmails(*requiredGroups)
+	}
+
+	deniedEmails := getFilteredStringSliceData(data, pathRolesDeniedEmailsProp)
+	if deniedEmails == nil {
+		r.DeniedEmails = []string{}
+	} else {
+		r.DeniedEmails = lowerAll(*deniedEmails)
+	}
+
+	deniedGroups := getFilteredStringSliceData(data, pathRolesDeniedGroupsProp)
+	if deniedGroups == nil {
+		r.DeniedGroups = []string{}
+	} else {
+		r.DeniedGroups = lowerEmails(*deniedGroups)
+	}
+
+	boundEmailPatterns := getFilteredStringSliceData(data, pathRolesBoundEmailPatternsProp)
+	if boundEmailPatterns == nil {
+		r.BoundEmailPatterns = []string{}
+	} else {
+		r.BoundEmailPatterns = *boundEmailPatterns
+	}
+
+	boundGroupPatterns := getFilteredStringSliceData(data, pathRolesBoundGroupPatternsProp)
+	if boundGroupPatterns == nil {
+		r.BoundGroupPatterns = []string{}
+	} else {
+		r.BoundGroupPatterns = *boundGroupPatterns
+	}
+
+	for _, pattern := range append(append([]string{}, r.BoundEmailPatterns...), r.BoundGroupPatterns...) {
+		if _, err := compilePattern(pattern); err != nil {
+			return fmt.Errorf("invalid pattern '%s': %s", pattern, err)
+		}
 	}
 
-	if len(r.BoundEmails)+len(r.BoundGroups) == 0 {
+	r.BoundGroupRoles = map[string][]string{}
+	if boundGroupRoles, ok := data.GetOk(pathRolesBoundGroupRolesProp); ok {
+		for group, roles := range boundGroupRoles.(map[string]string) {
+			group = lowerEmails([]string{strings.TrimSpace(group)})[0]
+			if strings.Contains(group, "@") && !isValidEmail(group) {
+				return fmt.Errorf("'%s' is not a valid group email address", group)
+			}
+
+			memberRoles := []string{}
+			for _, memberRole := range strings.FieldsFunc(roles, func(c rune) bool { return c == '|' || c == ',' }) {
+				memberRole = strings.ToUpper(strings.TrimSpace(memberRole))
+				if !sliceContains([]string{memberRole}, groupMemberRoles) {
+					return fmt.Errorf("'%s' is not a group membership role; must be one of %s", memberRole, strings.Join(groupMemberRoles, ", "))
+				}
+
+				memberRoles = append(memberRoles, memberRole)
+			}
+
+			if len(memberRoles) == 0 {
+				return fmt.Errorf("no membership role set for group '%s'", group)
+			}
+
+			r.BoundGroupRoles[group] = memberRoles
+		}
+	}
+
+	if r.bindingCount()+len(r.RequiredGroups) == 0 {
 		return fmt.Errorf("at least one email address or group must be set")
 	}
 
 	invalidEmailAddrs := []string{}
-	for _, emailAddr := range append(r.BoundEmails, r.BoundGroups...) {
+	for _, emailAddr := range append(r.BoundEmails, r.DeniedEmails...) {
 		if !isValidEmail(emailAddr) {
 			invalidEmailAddrs = append(invalidEmailAddrs, emailAddr)
 		}
 	}
 
+	for _, group := range append(append(r.BoundGroups, r.RequiredGroups...), r.DeniedGroups...) {
+		// groups may also be bound by their ID, which is not an email address
+		if strings.Contains(group, "@") && !isValidEmail(group) {
+			invalidEmailAddrs = append(invalidEmailAddrs, group)
+		}
+	}
+
 	if len(invalidEmailAddrs) > 0 {
 		return fmt.Errorf("one or more provided email addresses are invalid: %s", strings.Join(invalidEmailAddrs, ", "))
 	}
 
-	//////////////////////
+	if boundClaims, ok := data.GetOk(pathRolesBoundClaimsProp); ok {
+		r.BoundClaims = boundClaims.(map[string]string)
+	} else {
+		r.BoundClaims = map[string]string{}
+	}
+
+	r.BoundHostedDomains = []string{}
+	if boundDomains := getFilteredStringSliceData(data, pathRolesBoundDomainsProp); boundDomains != nil {
+		for _, domain := range *boundDomains {
+			if strings.Contains(domain, "@") {
+				return fmt.Errorf("'%s' is not a domain", domain)
+			}
+
+			r.BoundHostedDomains = append(r.BoundHostedDomains, strings.ToLower(domain))
+		}
+	}
 
-	if policies, ok := data.GetOk(pathRolesPoliciesProp); ok {
-		r.Policies = policyutil.ParsePolicies(policies)
+	if rejectConsumer, ok := data.GetOk(pathRolesRejectConsumerProp); ok {
+		r.RejectConsumerAccounts = rejectConsumer.(bool)
 	} else {
-		return fmt.Errorf("unable to retrieve policies")
+		r.RejectConsumerAccounts = false
 	}
 
-	if len(r.Policies) == 0 {
-		return fmt.Errorf("at least one policy must be defined")
+	if transitiveGroups, ok := data.GetOk(pathRolesTransitiveGroupsProp); ok {
+		r.TransitiveGroups = transitiveGroups.(bool)
+	} else {
+		r.TransitiveGroups = false
 	}
 
-	if strings.ToLower(r.Policies[0]) == "root" {
-		return fmt.Errorf("cannot use root policy")
+	r.MappedPolicies = strings.ToLower(strings.TrimSpace(data.Get(pathRolesMappedPoliciesProp).(string)))
+	if r.MappedPolicies != mappedPoliciesAppend && r.MappedPolicies != mappedPoliciesReplace {
+		return fmt.Errorf("'%s' must be either '%s' or '%s'", pathRolesMappedPoliciesProp, mappedPoliciesAppend, mappedPoliciesReplace)
 	}
 
-	//////////////////////
+	r.GroupPolicyTemplates = map[string]string{}
+	if groupPolicyTemplates, ok := data.GetOk(pathRolesGroupPolicyTemplatesProp); ok {
+		r.GroupPolicyTemplates = groupPolicyTemplates.(map[string]string)
+	}
 
-	if ttl, err := getPositiveIntData(data, pathRolesTTLProp); err == nil {
-		if ttl == nil {
-			// fallbacks to 1 hour when unset
-			r.TTL = time.Duration(1) * time.Hour
-		} else {
-			r.TTL = time.Duration(*ttl) * time.Second
+	for pattern, template := range r.GroupPolicyTemplates {
+		if _, err := compilePattern(pattern); err != nil {
+			return fmt.Errorf("invalid pattern '%s': %s", pattern, err)
 		}
+
+		if strings.TrimSpace(template) == "" {
+			return fmt.Errorf("no policy template set for pattern '%s'", pattern)
+		}
+	}
+
+	groupPolicyAllowlist := getFilteredStringSliceData(data, pathRolesGroupPolicyAllowlistProp)
+	if groupPolicyAllowlist == nil {
+		r.GroupPolicyAllowlist = []string{}
 	} else {
-		return err
+		r.GroupPolicyAllowlist = *groupPolicyAllowlist
+	}
+
+	groupPolicyDenylist := getFilteredStringSliceData(data, pathRolesGroupPolicyDenylistProp)
+	if groupPolicyDenylist == nil {
+		r.GroupPolicyDenylist = []string{}
+	} else {
+		r.GroupPolicyDenylist = *groupPolicyDenylist
 	}
 
-	if mttl, err := getPositiveIntData(data, pathRolesMaxTTLProp); err == nil {
-		if mttl == nil {
-			// fallbacks to 1 day when unset
-			r.MaxTTL = time.Duration(24) * time.Hour
-		} else {
-			r.MaxTTL = time.Duration(*mttl) * time.Second
+	for _, pattern := range append(append([]string{}, r.GroupPolicyAllowlist...), r.GroupPolicyDenylist...) {
+		if _, err := compilePattern(pattern); err != nil {
+			return fmt.Errorf("invalid pattern '%s': %s", pattern, err)
 		}
+	}
+
+	r.DisplayNameTemplate = strings.TrimSpace(data.Get(pathRolesDisplayNameTemplateProp).(string))
+	if err := validateMetadataTemplate(r.DisplayNameTemplate); err != nil {
+		return fmt.Errorf("invalid display name template: %s", err)
+	}
+
+	r.MetadataTemplates = map[string]string{}
+	if metadataTemplates, ok := data.GetOk(pathRolesMetadataTemplatesProp); ok {
+		r.MetadataTemplates = metadataTemplates.(map[string]string)
+	}
+
+	for key, text := range r.MetadataTemplates {
+		if err := validateMetadataTemplate(text); err != nil {
+			return fmt.Errorf("invalid template of metadata '%s': %s", key, err)
+		}
+	}
+
+	metadataGroupPatterns := getFilteredStringSliceData(data, pathRolesMetadataGroupPatternsProp)
+	if metadataGroupPatterns == nil {
+		r.MetadataGroupPatterns = []string{}
 	} else {
+		r.MetadataGroupPatterns = *metadataGroupPatterns
+	}
+
+	for _, pattern := range r.MetadataGroupPatterns {
+		if _, err := compilePattern(pattern); err != nil {
+			return fmt.Errorf("invalid pattern '%s': %s", pattern, err)
+		}
+	}
+
+	//////////////////////
+
+	// token parameters are replaced as a whole, like the other fields of the role
+	r.TokenParams = tokenutil.TokenParams{}
+	r.Policies, r.TTL, r.MaxTTL = nil, 0, 0
+
+	if err := r.ParseTokenFields(req, data); err != nil {
+		return err
+	}
+
+	// policies, ttl and max_ttl are still accepted in place of their token_* counterparts
+	if err := tokenutil.UpgradeValue(data, pathRolesPoliciesProp, "token_policies", &r.Policies, &r.TokenPolicies); err != nil {
+		return err
+	}
+
+	if err := tokenutil.UpgradeValue(data, pathRolesTTLProp, "token_ttl", &r.TTL, &r.TokenTTL); err != nil {
 		return err
 	}
 
-	if r.TTL.Hours() > r.MaxTTL.Hours() {
-		return fmt.Errorf("ttl (%s) cannot be greater than max_ttl (%s)", r.TTL.String(), r.MaxTTL.String())
+	if err := tokenutil.UpgradeValue(data, pathRolesMaxTTLProp, "token_max_ttl", &r.MaxTTL, &r.TokenMaxTTL); err != nil {
+		return err
+	}
+
+	// roles replacing their policies with the mapped ones, or deriving them from groups, need none of their own
+	if len(r.TokenPolicies) == 0 && r.MappedPolicies != mappedPoliciesReplace && len(r.GroupPolicyTemplates) == 0 {
+		return fmt.Errorf("at least one policy must be defined")
+	}
+
+	if err := validatePolicies(r.TokenPolicies); err != nil {
+		return err
+	}
+
+	//////////////////////
+
+	if r.TokenTTL < 0 || r.TokenMaxTTL < 0 {
+		return fmt.Errorf("value cannot be negative")
+	}
+
+	if r.TokenTTL == 0 {
+		// fallbacks to 1 hour when unset
+		r.TokenTTL = time.Duration(1) * time.Hour
+	}
+
+	if r.TokenMaxTTL == 0 {
+		// fallbacks to 1 day when unset
+		r.TokenMaxTTL = time.Duration(24) * time.Hour
+	}
+
+	if r.TokenTTL > r.TokenMaxTTL {
+		return fmt.Errorf("ttl (%s) cannot be greater than max_ttl (%s)", r.TokenTTL.String(), r.TokenMaxTTL.String())
 	}
 
 	return nil
 }
+
+///////////////////////////////////////////////////////////////////////////////
+
+// authCodeOptions returns the extra parameters of the Google OAuth flow URL for logins against this role. A role bound
+// to a single domain hints Google at it, so only accounts of that domain are offered.
+func (r *googleAuthRole) authCodeOptions() []oauth2.AuthCodeOption {
+	if r == nil || len(r.BoundHostedDomains) != 1 {
+		return nil
+	}
+
+	return []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("hd", r.BoundHostedDomains[0])}
+}
@@ -0,0 +1,75 @@
+package gaccauth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestRole_CRUD(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeRole("Eng", map[string]interface{}{"bound_groups": "eng@example.com", "bound_emails": "Bob@Gmail.com", "policies": "dev"})
+
+	resp := e.ok(logical.ReadOperation, "role/eng", nil)
+	if resp.Data["name"] != "eng" {
+		t.Fatalf("role names are case-insensitive; got %v", resp.Data["name"])
+	}
+
+	resp = e.ok(logical.ListOperation, "role/", nil)
+	if keys := resp.Data["keys"].([]string); len(keys) != 1 || keys[0] != "eng" {
+		t.Fatalf("unexpected roles %v", keys)
+	}
+
+	e.ok(logical.DeleteOperation, "role/eng", nil)
+	if resp := e.ok(logical.ReadOperation, "role/eng", nil); resp != nil {
+		t.Fatalf("role was not deleted: %v", resp.Data)
+	}
+}
+
+func TestRole_Validation(t *testing.T) {
+	e := newTestEnv(t)
+
+	for name, data := range map[string]map[string]interface{}{
+		"no bindings":   {"policies": "dev"},
+		"no policies":   {"bound_groups": "eng@example.com"},
+		"root policy":   {"bound_groups": "eng@example.com", "policies": "dev,root"},
+		"ttl above max": {"bound_groups": "eng@example.com", "policies": "dev", "ttl": "2h", "max_ttl": "1h"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			e.fails(logical.UpdateOperation, "role/invalid", data)
+		})
+	}
+}
+
+func TestRole_DeprecatedFields(t *testing.T) {
+	e := newTestEnv(t)
+
+	// a role stored before token parameters existed
+	entry, err := logical.StorageEntryJSON("role/legacy", map[string]interface{}{
+		"bound_groups": []string{"eng@example.com"},
+		"policies":     []string{"dev"},
+		"ttl":          10 * time.Minute,
+		"max_ttl":      time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.storage.Put(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := e.ok(logical.ReadOperation, "role/legacy", nil)
+	if resp.Data["token_ttl"] != int64(600) || resp.Data["token_max_ttl"] != int64(3600) {
+		t.Fatalf("deprecated TTLs were not carried over: %v", resp.Data)
+	}
+
+	auth := e.loginOK("alice@example.com", "legacy")
+	assertPolicies(t, auth, "dev")
+
+	if auth.TTL != 10*time.Minute || auth.MaxTTL != time.Hour {
+		t.Fatalf("unexpected TTLs %s and %s", auth.TTL, auth.MaxTTL)
+	}
+}
@@ -0,0 +1,280 @@
+package gaccauth
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// The web paths serve HTML pages straight from the plugin, so users can login from a browser without a separate web
+// application handling Google's redirect. The configured redirect URL should point at the callback page, i.e.
+// https://<VAULT-ADDR>/v1/auth/<MOUNT>/web/callback.
+const (
+	pathWebLoginPattern        = "web/login"
+	pathWebCallbackPattern     = "web/callback"
+	pathWebRoleNameProp        = "role"
+	pathWebListenerProp        = "listener"
+	pathWebStateProp           = "state"
+	pathWebGoogleAuthCodeProp  = "code"
+	pathWebGoogleAuthErrorProp = "error"
+	defaultWebTitle            = "Vault"
+)
+
+const webPageTemplate = `<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>{{ .Title }}</title>
+    <style>
+      body { font-family: sans-serif; background: #f5f5f5; color: #333; }
+      main { max-width: 32rem; margin: 4rem auto; padding: 2rem; background: #fff; border-radius: .5rem; text-align: center; }
+      img { max-height: 4rem; }
+      a.button { display: inline-block; padding: .75rem 1.5rem; border-radius: .25rem; background: #4285f4; color: #fff; text-decoration: none; }
+      code { display: block; padding: 1rem; background: #eee; word-break: break-all; user-select: all; }
+      .error { color: #c62828; }
+    </style>
+  </head>
+  <body>
+    <main>
+      {{ if .LogoURL }}<p><img src="{{ .LogoURL }}" alt=""></p>{{ end }}
+      <h1>{{ .Title }}</h1>
+      {{ if .Error }}
+      <p class="error">{{ .Error }}</p>
+      {{ else if .AuthURL }}
+      {{ if .HelpText }}<p>{{ .HelpText }}</p>{{ end }}
+      <p><a class="button" href="{{ .AuthURL }}">Sign in with Google</a></p>
+      {{ else }}
+      <p id="status">Signing in&hellip;</p>
+      <div id="token" hidden>
+        <p>Your Vault token is shown below. It will not be shown again.</p>
+        <code id="token-value"></code>
+      </div>
+      <form id="listener" method="POST" hidden><input type="hidden" name="token"></form>
+      <script>
+        const params = {{ .Callback }};
+        const status = document.getElementById('status');
+
+        fetch(params.login_url, {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ code: params.code, state: params.state, role: params.role })
+        })
+          .then((res) => res.json().then((body) => ({ ok: res.ok, body })))
+          .then(({ ok, body }) => {
+            if (!ok) {
+              throw new Error((body.errors || ['login failed']).join(', '));
+            }
+
+            if (params.listener) {
+              const form = document.getElementById('listener');
+              form.action = params.listener;
+              form.elements.token.value = body.auth.client_token;
+              form.submit();
+              return;
+            }
+
+            status.hidden = true;
+            document.getElementById('token-value').textContent = body.auth.client_token;
+            document.getElementById('token').hidden = false;
+          })
+          .catch((err) => {
+            status.className = 'error';
+            status.textContent = err.message;
+          });
+      </script>
+      {{ end }}
+    </main>
+  </body>
+</html>
+`
+
+var webPage = template.Must(template.New("page").Parse(webPageTemplate))
+
+type webPageData struct {
+	Title    string
+	LogoURL  string
+	HelpText string
+	Error    string
+	AuthURL  string
+	Callback GenericMap
+}
+
+func pathWeb(b *googleAccountAuthBackend) []*framework.Path {
+	login := &framework.Path{
+		Pattern:      pathWebLoginPattern,
+		HelpSynopsis: "HTML page to start a Google login from a browser.",
+		Fields: Schema{
+			pathWebRoleNameProp: {
+				Type:        framework.TypeString,
+				Description: "Name of the role against which the login is being attempted. Defaults to the configured default role",
+			},
+			pathWebListenerProp: {
+				Type:        framework.TypeString,
+				Description: "Loopback URL of a CLI listener the issued token should be posted to",
+			},
+		},
+		Callbacks: ActionCallback{
+			logical.ReadOperation: b.pathWebLogin,
+		},
+	}
+
+	callback := &framework.Path{
+		Pattern:      pathWebCallbackPattern,
+		HelpSynopsis: "HTML page Google redirects to after a login started from the web/login page.",
+		Fields: Schema{
+			pathWebStateProp: {
+				Type:        framework.TypeString,
+				Description: "State returned by Google along with the authentication code",
+			},
+			pathWebGoogleAuthCodeProp: {
+				Type:        framework.TypeString,
+				Description: "Google authentication code",
+			},
+			pathWebGoogleAuthErrorProp: {
+				Type:        framework.TypeString,
+				Description: "Error returned by Google when the login is not completed",
+			},
+		},
+		Callbacks: ActionCallback{
+			logical.ReadOperation: b.pathWebCallback,
+		},
+	}
+
+	return []*framework.Path{login, callback}
+}
+
+func (b *googleAccountAuthBackend) pathWebLogin(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	googleOAuth, err := b.getGoogleOAuthConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if googleOAuth == nil {
+		return webResponse(http.StatusInternalServerError, webPageData{Error: "The login is not configured."})
+	}
+
+	page := googleOAuth.webPageData()
+
+	roleName := data.Get(pathWebRoleNameProp).(string)
+	if roleName == "" {
+		roleName = googleOAuth.DefaultRole
+	}
+
+	if roleName == "" {
+		page.Error = "No role was given."
+		return webResponse(http.StatusBadRequest, page)
+	}
+
+	role, err := b.getDecodedRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	if role == nil {
+		page.Error = fmt.Sprintf("Role '%s' not found.", roleName)
+		return webResponse(http.StatusBadRequest, page)
+	}
+
+	listener := data.Get(pathWebListenerProp).(string)
+	if listener != "" && !isLoopbackUrl(listener) {
+		page.Error = "The listener must be a loopback URL."
+		return webResponse(http.StatusBadRequest, page)
+	}
+
+	state := &oauthState{
+		RoleName:    roleName,
+		RedirectURI: googleOAuth.RedirectURL,
+		Listener:    listener,
+	}
+
+	stateID, err := b.createOAuthState(ctx, req.Storage, state)
+	if err != nil {
+		return nil, err
+	}
+
+	page.AuthURL = googleOAuth.authCodeURL(stateID, state, role.authCodeOptions()...)
+
+	return webResponse(http.StatusOK, page)
+}
+
+func (b *googleAccountAuthBackend) pathWebCallback(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	googleOAuth, err := b.getGoogleOAuthConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if googleOAuth == nil {
+		return webResponse(http.StatusInternalServerError, webPageData{Error: "The login is not configured."})
+	}
+
+	page := googleOAuth.webPageData()
+
+	if googleErr := data.Get(pathWebGoogleAuthErrorProp).(string); googleErr != "" {
+		page.Error = fmt.Sprintf("Google did not complete the login: %s.", googleErr)
+		return webResponse(http.StatusBadRequest, page)
+	}
+
+	// the state is only looked at here; it is consumed by the login the page makes
+	stateID := data.Get(pathWebStateProp).(string)
+	state, err := b.getOAuthState(ctx, req.Storage, stateID)
+	if err != nil {
+		return nil, err
+	}
+
+	if state == nil || state.expired() {
+		page.Error = "The login has expired, please start over."
+		return webResponse(http.StatusBadRequest, page)
+	}
+
+	// relative to the page, so that mounts in Vault namespaces are reached through the same path prefix
+	page.Callback = GenericMap{
+		"login_url": "../" + pathLoginPattern,
+		"code":      data.Get(pathWebGoogleAuthCodeProp).(string),
+		"state":     stateID,
+		"role":      state.RoleName,
+		"listener":  state.Listener,
+	}
+
+	return webResponse(http.StatusOK, page)
+}
+
+///////////////////////////////////////////////////////////////////////////////
+
+func (c *googleOAuth) webPageData() webPageData {
+	page := webPageData{
+		Title:    c.WebTitle,
+		LogoURL:  c.WebLogoURL,
+		HelpText: c.WebHelpText,
+	}
+
+	if page.Title == "" {
+		page.Title = defaultWebTitle
+	}
+
+	return page
+}
+
+func webResponse(status int, page webPageData) (*logical.Response, error) {
+	var body bytes.Buffer
+	if err := webPage.Execute(&body, page); err != nil {
+		return nil, err
+	}
+
+	response := &logical.Response{
+		Data: GenericMap{
+			logical.HTTPContentType:        "text/html; charset=utf-8",
+			logical.HTTPRawBody:            body.Bytes(),
+			logical.HTTPStatusCode:         status,
+			logical.HTTPCacheControlHeader: "no-store",
+			logical.HTTPPragmaHeader:       "no-cache",
+		},
+	}
+
+	return response, nil
+}
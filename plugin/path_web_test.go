@@ -0,0 +1,86 @@
+package gaccauth
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// webPage makes the request to a web path, and returns the HTTP status and body of the page served.
+func (e *testEnv) webPage(path string, data map[string]interface{}) (int, string) {
+	e.t.Helper()
+
+	resp := e.ok(logical.ReadOperation, path, data)
+	return resp.Data[logical.HTTPStatusCode].(int), string(resp.Data[logical.HTTPRawBody].([]byte))
+}
+
+func TestWeb_Login(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeConfig(map[string]interface{}{
+		pathConfigDefaultRoleProp: "eng",
+		pathConfigWebTitleProp:    "<script>alert('title')</script>",
+		pathConfigWebHelpTextProp: "Use your <b>work</b> account",
+		pathConfigWebLogoURLProp:  "https://example.com/logo.png",
+	})
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev"})
+
+	status, body := e.webPage(pathWebLoginPattern, nil)
+	if status != http.StatusOK || !strings.Contains(body, "Sign in with Google") {
+		t.Fatalf("unexpected page %d: %s", status, body)
+	}
+
+	if strings.Contains(body, "<script>alert") || strings.Contains(body, "<b>work</b>") {
+		t.Fatalf("the configured texts must be escaped: %s", body)
+	}
+
+	if status, _ := e.webPage(pathWebLoginPattern, map[string]interface{}{pathWebListenerProp: "http://127.0.0.1:8250/token"}); status != http.StatusOK {
+		t.Fatalf("loopback listeners are allowed; got %d", status)
+	}
+
+	for _, listener := range []string{"https://attacker.example.com/token", "http://127.0.0.1.example.com/token"} {
+		if status, body := e.webPage(pathWebLoginPattern, map[string]interface{}{pathWebListenerProp: listener}); status != http.StatusBadRequest || !strings.Contains(body, "loopback") {
+			t.Fatalf("listener %s must be rejected; got %d", listener, status)
+		}
+	}
+
+	if status, _ := e.webPage(pathWebLoginPattern, map[string]interface{}{pathWebRoleNameProp: "unknown"}); status != http.StatusBadRequest {
+		t.Fatalf("unknown roles must be rejected; got %d", status)
+	}
+}
+
+func TestWeb_Callback(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeConfig(map[string]interface{}{pathConfigDefaultRoleProp: "eng"})
+	e.writeRole("eng", map[string]interface{}{"bound_groups": "eng@example.com", "policies": "dev"})
+
+	data := e.authorize("alice@example.com", "eng")
+	status, body := e.webPage(pathWebCallbackPattern, map[string]interface{}{
+		pathWebStateProp:          data[pathLoginStateProp],
+		pathWebGoogleAuthCodeProp: data[pathLoginGoogleAuthCodeProp],
+	})
+	if status != http.StatusOK || !strings.Contains(body, `"login_url":"../login"`) {
+		t.Fatalf("unexpected page %d: %s", status, body)
+	}
+
+	// the page only looks at the state; the login it makes consumes it
+	e.ok(logical.UpdateOperation, pathLoginPattern, data)
+
+	status, body = e.webPage(pathWebCallbackPattern, map[string]interface{}{
+		pathWebStateProp:          data[pathLoginStateProp],
+		pathWebGoogleAuthCodeProp: data[pathLoginGoogleAuthCodeProp],
+	})
+	if status != http.StatusBadRequest || !strings.Contains(body, "expired") {
+		t.Fatalf("consumed states must be rejected; got %d: %s", status, body)
+	}
+
+	if status, body := e.webPage(pathWebCallbackPattern, map[string]interface{}{pathWebStateProp: "unknown", pathWebGoogleAuthCodeProp: "code"}); status != http.StatusBadRequest || !strings.Contains(body, "expired") {
+		t.Fatalf("unknown states must be rejected; got %d: %s", status, body)
+	}
+
+	status, body = e.webPage(pathWebCallbackPattern, map[string]interface{}{pathWebGoogleAuthErrorProp: "access_denied"})
+	if status != http.StatusBadRequest || !strings.Contains(body, "access_denied") {
+		t.Fatalf("Google errors must be shown; got %d: %s", status, body)
+	}
+}
@@ -0,0 +1,138 @@
+package gaccauth
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	goauth "google.golang.org/api/oauth2/v2"
+)
+
+// metadataFields are the fields token metadata and display name templates can refer to, e.g. "{{.name}}".
+var metadataFields = []string{
+	"email", "user_id", "name", "given_name", "family_name", "hd", "locale", "role", "groups", "org_unit", "aliases",
+}
+
+// directoryMetadataFields are the metadata fields read from the Directory account of the user.
+var directoryMetadataFields = []string{"org_unit", "aliases"}
+
+func parseMetadataTemplate(text string) (*template.Template, error) {
+	return template.New("").Option("missingkey=error").Parse(text)
+}
+
+// validateMetadataTemplate checks that the template parses and only refers to known fields.
+func validateMetadataTemplate(text string) error {
+	tmpl, err := parseMetadataTemplate(text)
+	if err != nil {
+		return err
+	}
+
+	sample := map[string]string{}
+	for _, field := range metadataFields {
+		sample[field] = ""
+	}
+
+	return tmpl.Execute(&bytes.Buffer{}, sample)
+}
+
+func renderMetadataTemplate(text string, fields map[string]string) (string, error) {
+	tmpl, err := parseMetadataTemplate(text)
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, fields); err != nil {
+		return "", err
+	}
+
+	return out.String(), nil
+}
+
+// metadataTemplatesUse tells whether any of the role's templates refers to one of the fields.
+func (r *googleAuthRole) metadataTemplatesUse(fields []string) bool {
+	templates := []string{r.DisplayNameTemplate}
+	for _, text := range r.MetadataTemplates {
+		templates = append(templates, text)
+	}
+
+	for _, text := range templates {
+		for _, field := range fields {
+			if strings.Contains(text, "."+field) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+///////////////////////////////////////////////////////////////////////////////
+
+// tokenMetadata renders the display name and metadata of the token from the role's templates. The email address is
+// the display name, and the "username" metadata, unless the role overrides them. The Directory account of the user is
+// only read when a template refers to one of its fields.
+func (c *googleOAuth) tokenMetadata(roleName string, role *googleAuthRole, user *goauth.Userinfo, groups []*googleGroup) (string, map[string]string, error) {
+	groupEmails := []string{}
+	for _, group := range groups {
+		if len(role.MetadataGroupPatterns) == 0 || matchesAnyPattern(append([]string{group.Email}, group.Aliases...), role.MetadataGroupPatterns) {
+			groupEmails = append(groupEmails, group.Email)
+		}
+	}
+
+	fields := map[string]string{
+		"email":       user.Email,
+		"user_id":     user.Id,
+		"name":        user.Name,
+		"given_name":  user.GivenName,
+		"family_name": user.FamilyName,
+		"hd":          user.Hd,
+		"locale":      user.Locale,
+		"role":        roleName,
+		"groups":      strings.Join(groupEmails, ","),
+		"org_unit":    "",
+		"aliases":     "",
+	}
+
+	if role.metadataTemplatesUse(directoryMetadataFields) {
+		// the fields stay empty for accounts out of reach of the Directory, such as accounts of other customers
+		directoryUser, err := c.fetchDirectoryUser(user.Email)
+		if err != nil && !c.directoryOutOfReach(err, user.Email) {
+			return "", nil, err
+		}
+
+		if directoryUser != nil {
+			fields["org_unit"] = directoryUser.OrgUnitPath
+			fields["aliases"] = strings.Join(directoryUser.Aliases, ",")
+		}
+	}
+
+	displayName := user.Email
+	if role.DisplayNameTemplate != "" {
+		rendered, err := renderMetadataTemplate(role.DisplayNameTemplate, fields)
+		if err != nil {
+			return "", nil, fmt.Errorf("unable to render the display name: %s", err)
+		}
+
+		// fields may be empty, e.g. the name of an account without a profile
+		if strings.TrimSpace(rendered) != "" {
+			displayName = rendered
+		}
+	}
+
+	metadata := map[string]string{
+		"username": user.Email,
+	}
+
+	for key, text := range role.MetadataTemplates {
+		rendered, err := renderMetadataTemplate(text, fields)
+		if err != nil {
+			return "", nil, fmt.Errorf("unable to render metadata '%s': %s", key, err)
+		}
+
+		metadata[key] = rendered
+	}
+
+	return displayName, metadata, nil
+}
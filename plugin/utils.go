@@ -1,36 +1,23 @@
 package gaccauth
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/mail"
 	"net/url"
-	"sort"
+	"regexp"
 	"strings"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
 )
 
 type GenericMap map[string]interface{}
 
-func sliceEquals(a []string, b []string) bool {
-	sort.Strings(a)
-	sort.Strings(b)
-
-	if len(a) != len(b) {
-		return false
-	}
-
-	for i := 0; i < len(a); i++ {
-		if a[i] != b[i] {
-			return false
-		}
-	}
-
-	return true
-}
-
 // is any item of A contained in B?
 func sliceContains(a []string, b []string) bool {
 	for _, i := range a {
@@ -44,6 +31,15 @@ func sliceContains(a []string, b []string) bool {
 	return false
 }
 
+func lowerAll(values []string) []string {
+	lowered := make([]string, 0, len(values))
+	for _, value := range values {
+		lowered = append(lowered, strings.ToLower(value))
+	}
+
+	return lowered
+}
+
 func encodeToken(token *oauth2.Token) (string, error) {
 	buf, err := json.Marshal(token)
 
@@ -64,6 +60,35 @@ func decodeToken(encoded string) (*oauth2.Token, error) {
 	return &token, nil
 }
 
+// emailDomain returns the lower-cased domain of the email address.
+func emailDomain(email string) string {
+	return strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+}
+
+// isGoogleAPIError tells whether the error is a Google API error with one of the given HTTP status codes.
+func isGoogleAPIError(err error, codes ...int) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	if !ok {
+		return false
+	}
+
+	for _, code := range codes {
+		if apiErr.Code == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+func stringOrDefault(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+
+	return value
+}
+
 func getPositiveIntData(data *framework.FieldData, prop string) (*int, error) {
 	if v, ok := data.GetOk(prop); ok {
 		value := v.(int)
@@ -122,7 +147,67 @@ func isValidUrl(addr string) bool {
 	return true
 }
 
+// isLoopbackUrl tells whether the address is a plain HTTP URL on the local machine.
+func isLoopbackUrl(addr string) bool {
+	u, err := url.Parse(addr)
+
+	if err != nil || strings.ToLower(u.Scheme) != "http" {
+		return false
+	}
+
+	host := u.Hostname()
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 func isValidEmail(addr string) bool {
 	_, err := mail.ParseAddress(addr)
 	return err == nil
 }
+
+func generateRandomString(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// compilePattern compiles a glob (e.g. "team-*-admins@example.com") or, when enclosed in slashes, a regular expression
+// (e.g. "/^[a-z]+@data\.example\.com$/"). Both are matched case-insensitively and must match the whole value. Each
+// wildcard of a glob is a capture group.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	var expr string
+
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		expr = pattern[1 : len(pattern)-1]
+	} else {
+		expr = strings.NewReplacer(`\*`, "(.*)", `\?`, "(.)").Replace(regexp.QuoteMeta(pattern))
+	}
+
+	return regexp.Compile("(?i)^(?:" + expr + ")$")
+}
+
+// matchesAnyPattern tells whether any of the values matches any of the patterns. Patterns that do not compile, which
+// role validation prevents, match nothing.
+func matchesAnyPattern(values []string, patterns []string) bool {
+	for _, pattern := range patterns {
+		re, err := compilePattern(pattern)
+		if err != nil {
+			continue
+		}
+
+		for _, value := range values {
+			if re.MatchString(value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
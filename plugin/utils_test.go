@@ -0,0 +1,34 @@
+package gaccauth
+
+import "testing"
+
+func TestCompilePattern(t *testing.T) {
+	for _, c := range []struct {
+		pattern string
+		value   string
+		matches bool
+	}{
+		{"*@data.example.com", "ana@data.example.com", true},
+		{"*@data.example.com", "ANA@Data.Example.com", true},
+		{"*@data.example.com", "ana@example.com", false},
+		{"*@data.example.com", "ana@data.example.com.evil", false},
+		{"team-?-admins@example.com", "team-a-admins@example.com", true},
+		{"team-?-admins@example.com", "team-ab-admins@example.com", false},
+		{"/team-[a-z]+-admins@example\\.com/", "team-sre-admins@example.com", true},
+		{"/team-[a-z]+-admins@example\\.com/", "xteam-sre-admins@example.com", false},
+		{"/a|b/", "ab", false},
+	} {
+		re, err := compilePattern(c.pattern)
+		if err != nil {
+			t.Fatalf("%s: %s", c.pattern, err)
+		}
+
+		if re.MatchString(c.value) != c.matches {
+			t.Errorf("%s matching %s should be %t", c.pattern, c.value, c.matches)
+		}
+	}
+
+	if _, err := compilePattern("/(/"); err == nil {
+		t.Fatal("invalid regular expressions must be rejected")
+	}
+}
@@ -23,6 +23,6 @@ class Vault:
     def get_vault_roles(self):
         return self._client.auth.approle.list_roles(mount_point=self._auth_path)
 
-    def get_vault_token(self, code, role):
-        res = self._client.write(f'auth/{self._auth_path}/login', code=code, role=role)
+    def get_vault_token(self, code, state, role):
+        res = self._client.write(f'auth/{self._auth_path}/login', code=code, state=state, role=role)
         return res['auth']['client_token']
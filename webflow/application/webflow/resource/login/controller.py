@@ -9,9 +9,10 @@ class VaultLoginWithGoogleAccHandler(Controller):
     def post(self):
         try:
             google_oauth_code = self.fetch_form_val('code')
+            google_oauth_state = self.fetch_form_val('state')
             vault_role = self.fetch_form_val('role')
 
-            vault_token = self.service.vault.get_vault_token(google_oauth_code, vault_role)
+            vault_token = self.service.vault.get_vault_token(google_oauth_code, google_oauth_state, vault_role)
             return self.render_page('token.html', token=vault_token)
 
         except VaultConnectionError as e:
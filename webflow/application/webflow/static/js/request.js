@@ -3,6 +3,7 @@ function getPayloadData () {
 
   return {
     code: decodeURIComponent(queryParams.get('code')),
+    state: decodeURIComponent(queryParams.get('state')),
     role: document.getElementById('role-list').value
   }
 }